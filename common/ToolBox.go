@@ -4,21 +4,60 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sunvc/apns2"
 )
 
+// NotPushedData 重试队列中的消息
 type NotPushedData struct {
 	ID           string          `json:"id"`
+	State        string          `json:"state"`
 	CreateDate   time.Time       `json:"createDate"`
 	LastPushDate time.Time       `json:"lastPushDate"`
+	NextPushDate time.Time       `json:"nextPushDate"`
 	Count        int             `json:"count"`
+	LastError    string          `json:"lastError,omitempty"`
 	Params       *ParamsResult   `json:"params"`
 	PushType     apns2.EPushType `json:"pushType"`
 }
 
+// ScheduledData 等待到期发送的定时推送
+type ScheduledData struct {
+	ID         string          `json:"id"`
+	SendAt     time.Time       `json:"sendAt"`
+	CreateDate time.Time       `json:"createDate"`
+	Admin      bool            `json:"admin"` // 管理员创建，发送后加入重试队列
+	Params     *ParamsResult   `json:"params"`
+	PushType   apns2.EPushType `json:"pushType"`
+}
+
+// CronJob 按 cron 表达式周期发送的推送任务
+type CronJob struct {
+	ID         string                 `json:"id"`
+	Spec       string                 `json:"spec"`
+	TimeZone   string                 `json:"timezone"`
+	Paused     bool                   `json:"paused"`
+	Admin      bool                   `json:"admin"` // 管理员创建，发送后加入重试队列
+	Keys       []string               `json:"keys"`
+	Params     map[string]interface{} `json:"params"` // 原始推送参数，每次触发时重新解析
+	CreateDate time.Time              `json:"createDate"`
+	NextRun    time.Time              `json:"nextRun"`
+	LastRun    time.Time              `json:"lastRun"`
+	LastError  string                 `json:"lastError,omitempty"`
+}
+
+// LocalLocation 返回 system.time_zone 配置的时区，无效时使用 UTC
+func LocalLocation() *time.Location {
+	if loc, err := time.LoadLocation(LocalConfig.System.TimeZone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
 func BaseDir(path ...string) string {
 	dataDir := LocalConfig.System.DataDir
 	if len(path) == 0 {
@@ -73,6 +112,12 @@ func GetClientHost(c *gin.Context) string {
 	return fmt.Sprintf("%s://%s", scheme, host)
 }
 
+// ClientIP 返回客户端 IP
+// 只有来自 trusted_proxies 的请求才读取 proxy_header，并从右向左取第一个不受信任的地址，客户端无法伪造
+func ClientIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
 func IsFileInDirectory(dirPath, fileName string) (bool, error) {
 	// 对目录路径进行规范化处理
 	dirPath = filepath.Clean(dirPath)
@@ -115,3 +160,29 @@ func FilterShortStrings(input []string, minNumber, maxNumber int) []string {
 	}
 	return result
 }
+
+// NormalizeEnv 规范化 APNs 环境名称，无法识别时返回 false
+func NormalizeEnv(env string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(env)) {
+	case "":
+		return "", true
+	case EnvDevelopment, "sandbox", "develop", "dev":
+		return EnvDevelopment, true
+	case EnvProduction, "prod":
+		return EnvProduction, true
+	}
+	return "", false
+}
+
+// NormalizePlatform 规范化设备平台名称，为空时为 iOS，无法识别时返回 false
+func NormalizePlatform(platform string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(platform)) {
+	case "", PlatformIOS, "ipados", "macos", "apns":
+		return PlatformIOS, true
+	case PlatformAndroid, "fcm":
+		return PlatformAndroid, true
+	case PlatformWeb, "webpush", "browser":
+		return PlatformWeb, true
+	}
+	return "", false
+}
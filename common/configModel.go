@@ -1,8 +1,12 @@
 package common
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/knadh/koanf/parsers/yaml"
@@ -10,9 +14,15 @@ import (
 	"github.com/knadh/koanf/v2"
 )
 
+// ConcurrencyDeprecated 配置了 system.concurrency 时的提示
+const ConcurrencyDeprecated = "system.concurrency is deprecated and ignored, use system.workers and system.queue_size"
+
 type Config struct {
-	System System `mapstructure:"system" json:"system" yaml:"system" koanf:"system"`
-	Apple  Apple  `mapstructure:"apple" json:"apple" yaml:"apple" koanf:"apple"`
+	System  System  `mapstructure:"system" json:"system" yaml:"system" koanf:"system"`
+	Apple   Apple   `mapstructure:"apple" json:"apple" yaml:"apple" koanf:"apple"` // 默认 App
+	Apps    []Apple `mapstructure:"-" json:"apps,omitempty" yaml:"-" koanf:"-"`    // apple 配置为列表时的全部 App，第一个为默认 App
+	FCM     FCM     `mapstructure:"fcm" json:"fcm" yaml:"fcm" koanf:"fcm"`
+	WebPush WebPush `mapstructure:"webpush" json:"webpush" yaml:"webpush" koanf:"webpush"`
 }
 
 // System 是 NoLets/Bark 服务的配置结构体
@@ -29,10 +39,14 @@ type System struct {
 	Key                   string        `mapstructure:"key" json:"key" yaml:"key" koanf:"key"`
 	ReduceMemoryUsage     bool          `mapstructure:"reduce_memory_usage" json:"reduce_memory_usage" yaml:"reduce_memory_usage" koanf:"reduce_memory_usage"`
 	ProxyHeader           string        `mapstructure:"proxy_header" json:"proxy_header" yaml:"proxy_header" koanf:"proxy_header"`
+	TrustedProxies        []string      `mapstructure:"trusted_proxies" json:"trusted_proxies" yaml:"trusted_proxies" koanf:"trusted_proxies"`
 	MaxBatchPushCount     int           `mapstructure:"max_batch_push_count" json:"max_batch_push_count" yaml:"max_batch_push_count" koanf:"max_batch_push_count"`
 	MaxAPNSClientCount    int           `mapstructure:"max_apns_client_count" json:"max_apns_client_count" yaml:"max_apns_client_count" koanf:"max_apns_client_count"`
+	MaxAPNSStreams        int           `mapstructure:"max_apns_streams" json:"max_apns_streams" yaml:"max_apns_streams" koanf:"max_apns_streams"`
 	MaxDeviceKeyArrLength int           `mapstructure:"max_device_key_arr_length" json:"max_device_key_arr_length" yaml:"max_device_key_arr_length" koanf:"max_device_key_arr_length"`
-	Concurrency           int           `mapstructure:"concurrency" json:"concurrency" yaml:"concurrency" koanf:"concurrency"`
+	Concurrency           int           `mapstructure:"concurrency" json:"concurrency,omitempty" yaml:"concurrency" koanf:"concurrency"` // 已废弃，推送并发由 workers 和 queue_size 决定
+	Workers               int           `mapstructure:"workers" json:"workers" yaml:"workers" koanf:"workers"`
+	QueueSize             int           `mapstructure:"queue_size" json:"queue_size" yaml:"queue_size" koanf:"queue_size"`
 	ReadTimeout           time.Duration `mapstructure:"read_timeout" json:"read_timeout" yaml:"read_timeout" koanf:"read_timeout"`
 	WriteTimeout          time.Duration `mapstructure:"write_timeout" json:"write_timeout" yaml:"write_timeout" koanf:"write_timeout"`
 	IdleTimeout           time.Duration `mapstructure:"idle_timeout" json:"idle_timeout" yaml:"idle_timeout" koanf:"idle_timeout"`
@@ -45,14 +59,44 @@ type System struct {
 	TimeZone              string        `mapstructure:"time_zone" json:"time_zone" yaml:"time_zone" koanf:"time_zone"`
 	Voice                 bool          `mapstructure:"voice" json:"voice" yaml:"voice" koanf:"voice"`
 	Auths                 []string      `mapstructure:"auths" json:"auths" yaml:"auths" koanf:"auths"`
+	MaxRetryCount         int           `mapstructure:"max_retry_count" json:"max_retry_count" yaml:"max_retry_count" koanf:"max_retry_count"`
+	RetryInterval         time.Duration `mapstructure:"retry_interval" json:"retry_interval" yaml:"retry_interval" koanf:"retry_interval"`
+	RateLimitKey          int           `mapstructure:"rate_limit_key" json:"rate_limit_key" yaml:"rate_limit_key" koanf:"rate_limit_key"`
+	RateLimitIP           int           `mapstructure:"rate_limit_ip" json:"rate_limit_ip" yaml:"rate_limit_ip" koanf:"rate_limit_ip"`
+	RateLimitAdmin        int           `mapstructure:"rate_limit_admin" json:"rate_limit_admin" yaml:"rate_limit_admin" koanf:"rate_limit_admin"`
+	RateLimitShared       bool          `mapstructure:"rate_limit_shared" json:"rate_limit_shared" yaml:"rate_limit_shared" koanf:"rate_limit_shared"`
+	DefaultTTL            time.Duration `mapstructure:"default_ttl" json:"default_ttl" yaml:"default_ttl" koanf:"default_ttl"`
+	DefaultPriority       int           `mapstructure:"default_priority" json:"default_priority" yaml:"default_priority" koanf:"default_priority"`
+	DefaultLevel          string        `mapstructure:"default_level" json:"default_level" yaml:"default_level" koanf:"default_level"`
+	LongMessage           string        `mapstructure:"long_message" json:"long_message" yaml:"long_message" koanf:"long_message"`
+	MessageRetention      time.Duration `mapstructure:"message_retention" json:"message_retention" yaml:"message_retention" koanf:"message_retention"`
+	IdempotencyWindow     time.Duration `mapstructure:"idempotency_window" json:"idempotency_window" yaml:"idempotency_window" koanf:"idempotency_window"`
 }
 
+// Apple 一个 App 的推送配置，Name 用于设备注册时选择 App，未配置时使用 Topic
 type Apple struct {
+	Name           string `mapstructure:"name" json:"name,omitempty" yaml:"name" koanf:"name"`
 	ApnsPrivateKey string `mapstructure:"apnsPrivateKey" json:"apnsPrivateKey" yaml:"apnsPrivateKey" koanf:"apnsPrivateKey"`
 	Topic          string `mapstructure:"topic" json:"topic" yaml:"topic" koanf:"topic"`
 	KeyID          string `mapstructure:"keyID" json:"keyID" yaml:"keyID" koanf:"keyID"`
 	TeamID         string `mapstructure:"teamID" json:"teamID" yaml:"teamID" koanf:"teamID"`
 	Develop        bool   `mapstructure:"develop" json:"develop" yaml:"develop" koanf:"develop"`
+	CertFile       string `mapstructure:"cert_file" json:"cert_file" yaml:"cert_file" koanf:"cert_file"`
+	CertPassword   string `mapstructure:"cert_password" json:"-" yaml:"cert_password" koanf:"cert_password"`
+	Host           string `mapstructure:"host" json:"host,omitempty" yaml:"host" koanf:"host"`
+}
+
+// FCM Android 设备的推送配置，使用 Firebase 服务账号认证，未配置 credentials 时不启用
+type FCM struct {
+	Credentials string `mapstructure:"credentials" json:"-" yaml:"credentials" koanf:"credentials"` // 服务账号 JSON 文件
+	ProjectID   string `mapstructure:"project_id" json:"project_id,omitempty" yaml:"project_id" koanf:"project_id"`
+	Host        string `mapstructure:"host" json:"host,omitempty" yaml:"host" koanf:"host"`
+}
+
+// WebPush 浏览器推送配置，VAPID 密钥在首次启动时生成并保存在数据目录
+type WebPush struct {
+	Subject   string `mapstructure:"subject" json:"subject" yaml:"subject" koanf:"subject"`             // VAPID 联系方式，mailto: 或 https: 地址
+	AllowHTTP bool   `mapstructure:"allow_http" json:"allow_http" yaml:"allow_http" koanf:"allow_http"` // 允许 http 和内网推送地址，仅用于本地测试
 }
 
 func (global *Config) SetConfig(configPath string) {
@@ -70,7 +114,24 @@ func (global *Config) SetConfig(configPath string) {
 		return
 	}
 
-	if err := ko.Unmarshal("", &conf); err != nil {
+	if err := ko.Unmarshal("system", &conf.System); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if err := ko.Unmarshal("fcm", &conf.FCM); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if err := ko.Unmarshal("webpush", &conf.WebPush); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	// apple 可以是单个 App，也可以是多个 App 的列表
+	isList, err := unmarshalApple(ko, &conf)
+	if err != nil {
 		log.Fatal(err)
 		return
 	}
@@ -109,14 +170,27 @@ func (global *Config) SetConfig(configPath string) {
 	if len(conf.System.ProxyHeader) > 0 {
 		global.System.ProxyHeader = conf.System.ProxyHeader
 	}
+	if len(conf.System.TrustedProxies) > 0 {
+		global.System.TrustedProxies = conf.System.TrustedProxies
+	}
 	if conf.System.MaxBatchPushCount > 0 {
 		global.System.MaxBatchPushCount = conf.System.MaxBatchPushCount
 	}
 	if conf.System.MaxAPNSClientCount > 0 {
 		global.System.MaxAPNSClientCount = conf.System.MaxAPNSClientCount
 	}
+	if conf.System.MaxAPNSStreams > 0 {
+		global.System.MaxAPNSStreams = conf.System.MaxAPNSStreams
+	}
 	if conf.System.Concurrency > 0 {
 		global.System.Concurrency = conf.System.Concurrency
+		log.Println(ConcurrencyDeprecated)
+	}
+	if conf.System.Workers > 0 {
+		global.System.Workers = conf.System.Workers
+	}
+	if conf.System.QueueSize > 0 {
+		global.System.QueueSize = conf.System.QueueSize
 	}
 	if conf.System.ReadTimeout > 0 {
 		global.System.ReadTimeout = conf.System.ReadTimeout
@@ -147,19 +221,182 @@ func (global *Config) SetConfig(configPath string) {
 		global.System.TimeZone = conf.System.TimeZone
 	}
 	global.System.Voice = conf.System.Voice
+	if conf.System.MaxRetryCount > 0 {
+		global.System.MaxRetryCount = conf.System.MaxRetryCount
+	}
+	if conf.System.RetryInterval > 0 {
+		global.System.RetryInterval = conf.System.RetryInterval
+	}
+	// 限流配置允许设置为 0 关闭，所以按是否配置过判断
+	if ko.Exists("system.rate_limit_key") {
+		global.System.RateLimitKey = conf.System.RateLimitKey
+	}
+	if ko.Exists("system.rate_limit_ip") {
+		global.System.RateLimitIP = conf.System.RateLimitIP
+	}
+	if ko.Exists("system.rate_limit_admin") {
+		global.System.RateLimitAdmin = conf.System.RateLimitAdmin
+	}
+	global.System.RateLimitShared = conf.System.RateLimitShared
+	// 投递默认值为 0 时表示不设置对应的 APNs 请求头
+	if ko.Exists("system.default_ttl") {
+		global.System.DefaultTTL = conf.System.DefaultTTL
+	}
+	if ko.Exists("system.default_priority") {
+		global.System.DefaultPriority = conf.System.DefaultPriority
+	}
+	if len(conf.System.DefaultLevel) > 0 {
+		global.System.DefaultLevel = conf.System.DefaultLevel
+	}
+	if len(conf.System.LongMessage) > 0 {
+		global.System.LongMessage = conf.System.LongMessage
+	}
+	// 保存时长和幂等窗口设置为 0 时分别表示只保存到推送过期时间和关闭幂等
+	if ko.Exists("system.message_retention") {
+		global.System.MessageRetention = conf.System.MessageRetention
+	}
+	if ko.Exists("system.idempotency_window") {
+		global.System.IdempotencyWindow = conf.System.IdempotencyWindow
+	}
+	// 检查FCM字段
+	if len(conf.FCM.Credentials) > 0 {
+		global.FCM.Credentials = conf.FCM.Credentials
+	}
+	if len(conf.FCM.ProjectID) > 0 {
+		global.FCM.ProjectID = conf.FCM.ProjectID
+	}
+	if len(conf.FCM.Host) > 0 {
+		global.FCM.Host = conf.FCM.Host
+	}
+	// 检查WebPush字段
+	if len(conf.WebPush.Subject) > 0 {
+		global.WebPush.Subject = conf.WebPush.Subject
+	}
+	global.WebPush.AllowHTTP = conf.WebPush.AllowHTTP
 	// 检查Apple字段
-	if len(conf.Apple.ApnsPrivateKey) > 0 {
-		global.Apple.ApnsPrivateKey = conf.Apple.ApnsPrivateKey
+	global.Apple.merge(conf.Apple)
+	if isList {
+		global.Apps = conf.Apps
+		global.Apple = conf.Apps[0]
+	}
+
+}
+
+// unmarshalApple 解析 apple 配置，为列表时写入 conf.Apps，否则写入 conf.Apple
+func unmarshalApple(ko *koanf.Koanf, conf *Config) (bool, error) {
+	if _, ok := ko.Get("apple").([]interface{}); !ok {
+		return false, ko.Unmarshal("apple", &conf.Apple)
 	}
-	if len(conf.Apple.Topic) > 0 {
-		global.Apple.Topic = conf.Apple.Topic
+
+	if err := ko.Unmarshal("apple", &conf.Apps); err != nil {
+		return true, err
 	}
-	if len(conf.Apple.KeyID) > 0 {
-		global.Apple.KeyID = conf.Apple.KeyID
+	if len(conf.Apps) == 0 {
+		return true, errors.New("apple app list is empty")
 	}
-	if len(conf.Apple.TeamID) > 0 {
-		global.Apple.TeamID = conf.Apple.TeamID
+	seen := map[string]bool{}
+	for _, app := range conf.Apps {
+		if seen[app.ID()] {
+			return true, fmt.Errorf("duplicate apple app name: %s", app.ID())
+		}
+		seen[app.ID()] = true
 	}
-	global.Apple.Develop = conf.Apple.Develop
+	return true, nil
+}
 
+// merge 使用配置文件中设置了的字段覆盖当前配置
+func (a *Apple) merge(conf Apple) {
+	if len(conf.ApnsPrivateKey) > 0 {
+		a.ApnsPrivateKey = conf.ApnsPrivateKey
+	}
+	if len(conf.Topic) > 0 {
+		a.Topic = conf.Topic
+	}
+	if len(conf.KeyID) > 0 {
+		a.KeyID = conf.KeyID
+	}
+	if len(conf.TeamID) > 0 {
+		a.TeamID = conf.TeamID
+	}
+	a.Develop = conf.Develop
+	if len(conf.CertFile) > 0 {
+		a.CertFile = conf.CertFile
+	}
+	if len(conf.CertPassword) > 0 {
+		a.CertPassword = conf.CertPassword
+	}
+	if len(conf.Host) > 0 {
+		a.Host = conf.Host
+	}
+}
+
+// ReadAppleApps 重新读取配置文件中的 App 配置，用于不重启更新推送凭证
+// 单个 App 时在 base 的基础上覆盖配置文件中设置了的字段
+func ReadAppleApps(configPath string, base Apple) ([]Apple, error) {
+	ko := koanf.New(".")
+	if err := ko.Load(file.Provider(configPath), yaml.Parser()); err != nil {
+		return nil, err
+	}
+
+	var conf Config
+	isList, err := unmarshalApple(ko, &conf)
+	if err != nil {
+		return nil, err
+	}
+	if isList {
+		return conf.Apps, nil
+	}
+	base.merge(conf.Apple)
+	return []Apple{base}, nil
+}
+
+// PrivateKey 返回 .p8 私钥内容，apnsPrivateKey 不是 PEM 内容时按文件路径读取
+func (a Apple) PrivateKey() ([]byte, error) {
+	if a.ApnsPrivateKey == "" || strings.Contains(a.ApnsPrivateKey, "-----BEGIN") {
+		return []byte(a.ApnsPrivateKey), nil
+	}
+	return os.ReadFile(a.ApnsPrivateKey)
+}
+
+// ID 返回 App 的名称，未配置 name 时使用 topic
+func (a Apple) ID() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Topic
+}
+
+// appsMu 保护重新加载时对 App 配置的更新
+var appsMu sync.RWMutex
+
+// AppleApps 返回所有 App 配置，第一个为默认 App
+func AppleApps() []Apple {
+	appsMu.RLock()
+	defer appsMu.RUnlock()
+	if len(LocalConfig.Apps) > 0 {
+		return LocalConfig.Apps
+	}
+	return []Apple{LocalConfig.Apple}
+}
+
+// SetAppleApps 替换全部 App 配置，第一个为默认 App
+func SetAppleApps(apps []Apple) {
+	appsMu.Lock()
+	defer appsMu.Unlock()
+	LocalConfig.Apps = apps
+	LocalConfig.Apple = apps[0]
+}
+
+// AppleApp 按名称查找 App 配置，名称为空时返回默认 App
+func AppleApp(name string) (Apple, bool) {
+	apps := AppleApps()
+	if name == "" {
+		return apps[0], true
+	}
+	for _, app := range apps {
+		if app.ID() == name {
+			return app, true
+		}
+	}
+	return Apple{}, false
 }
@@ -16,6 +16,7 @@ const (
 	Callback     = "callback"    // 回调
 	Subtitle     = "subtitle"    // 副标题
 	CipherText   = "ciphertext"  // 密文
+	Encrypt      = "encrypt"     // 服务端使用设备公钥加密
 	Body         = "body"        // 内容
 	Content      = "content"     // 内容（兼容）
 	Text         = "text"        // 内容（兼容）
@@ -29,16 +30,73 @@ const (
 	MD           = "md"          // 是否是markdown格式（简写）
 	CurrentIndex = "index"       // index
 	TotalCount   = "count"       // count
+	SendAt       = "sendat"      // 定时推送时间
+	Delay        = "delay"       // 延迟推送时长
+	Icon         = "icon"        // 图标地址
+	URL          = "url"         // 点击后打开的地址
+
+	// 投递选项
+	TTL            = "ttl"            // 离线保存时长
+	Expiration     = "expiration"     // 过期时间
+	Priority       = "priority"       // APNs 优先级
+	RelevanceScore = "relevancescore" // 通知摘要中的排序权重
+	Volume         = "volume"         // 重要提醒的音量
+
+	// 实时活动参数
+	Event          = "event"          // start / update / end
+	ContentState   = "contentstate"   // 实时活动的动态内容
+	AttributesType = "attributestype" // ActivityAttributes 类型名，start 时必填
+	Attributes     = "attributes"     // ActivityAttributes 的静态内容，start 时必填
+	StaleDate      = "staledate"      // 内容过期时间
+	DismissalDate  = "dismissaldate"  // 结束后从锁屏移除的时间
+	ActivityID     = "activityid"     // 只更新指定的实时活动
 
 	UserName = "username"
 	Password = "password"
 )
 
+// 长消息参数
+const (
+	LongMessage  = "longmessage"  // 长消息的发送方式
+	MessageID    = "messageid"    // 服务端保存的长消息 ID
+	MessageToken = "messagetoken" // 获取长消息的 token
+)
+
+// 幂等推送参数
+const (
+	IdempotencyKey           = "idempotencykey"      // 推送参数中的幂等 key
+	HeaderIdempotencyKey     = "Idempotency-Key"     // 请求头中的幂等 key，优先于参数
+	HeaderIdempotentReplayed = "Idempotent-Replayed" // 返回保存的结果时设置为 true
+)
+
+// 实时活动事件
+const (
+	LiveActivityStart  = "start"
+	LiveActivityUpdate = "update"
+	LiveActivityEnd    = "end"
+)
+
+// APNs 环境，对应 App 的 aps-environment 权限
+const (
+	EnvDevelopment = "development" // Xcode 调试构建
+	EnvProduction  = "production"  // TestFlight / App Store
+)
+
+// 设备平台，决定使用哪个推送通道
+const (
+	PlatformIOS     = "ios"     // APNs
+	PlatformAndroid = "android" // FCM
+	PlatformWeb     = "web"     // Web Push
+)
+
 const (
 	HeaderContentType   = "Content-Type"
 	HeaderUserAgent     = "User-Agent"
 	MIMEImageJpeg       = "image/jpeg"
 	MIMEImagePng        = "image/png"
 	MIMEImageSvg        = "image/svg+xml"
+	MIMEImageGif        = "image/gif"
+	MIMEImageWebp       = "image/webp"
+	MIMEImageHeic       = "image/heic"
 	MIMEApplicationJSON = "application/json"
 )
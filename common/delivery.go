@@ -0,0 +1,190 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 打断级别，对应 aps.interruption-level
+const (
+	LevelPassive       = "passive"
+	LevelActive        = "active"
+	LevelTimeSensitive = "timeSensitive"
+	LevelCritical      = "critical"
+)
+
+// APNs 优先级
+const (
+	PriorityConserve  = 1  // 优先考虑设备电量，可能延迟或合并投递
+	PriorityLow       = 5  // 按设备电量投递，静默推送只能使用此优先级或更低
+	PriorityImmediate = 10 // 立即投递
+)
+
+// MaxTTL APNs 保存离线消息的最长时间
+const MaxTTL = 30 * 24 * time.Hour
+
+// DefaultCriticalVolume 重要提醒未指定 volume 时的音量
+const DefaultCriticalVolume = 1.0
+
+// Delivery 推送的投递选项
+type Delivery struct {
+	TTL            time.Duration // 发送时间 + TTL 作为过期时间
+	Expiration     time.Time     // 指定的过期时间，优先于 TTL
+	Priority       int           // 0 表示不设置，由 APNs 决定
+	Level          string        // 打断级别
+	RelevanceScore *float64      // 通知摘要中的排序权重，0 ~ 1
+	Volume         float64       // 重要提醒的音量，0 ~ 1
+}
+
+// ExpiresAt 返回在 now 发送时的过期时间，零值表示不设置
+func (d *Delivery) ExpiresAt(now time.Time) time.Time {
+	if !d.Expiration.IsZero() {
+		return d.Expiration
+	}
+	if d.TTL > 0 {
+		return now.Add(d.TTL)
+	}
+	return time.Time{}
+}
+
+// ParseDelivery 解析 ttl / expiration / priority / level / relevance-score / volume 参数
+// 未指定的参数使用 system 中的默认值，background 为静默推送
+func ParseDelivery(params *ParamsMap, background bool) (*Delivery, error) {
+	system := LocalConfig.System
+	d := &Delivery{TTL: system.DefaultTTL, Volume: DefaultCriticalVolume}
+
+	ttl, ttlOk := params.Get(TTL)
+	ttlOk = ttlOk && strings.TrimSpace(valueString(ttl)) != ""
+	expiration, expOk := params.Get(Expiration)
+	expOk = expOk && strings.TrimSpace(valueString(expiration)) != ""
+
+	switch {
+	case ttlOk && expOk:
+		return nil, fmt.Errorf("%s and %s cannot be used together", TTL, Expiration)
+	case ttlOk:
+		v, err := ParseDurationValue(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", TTL, err)
+		}
+		if v < time.Second || v > MaxTTL {
+			return nil, fmt.Errorf("%s must be between 1s and %s", TTL, MaxTTL)
+		}
+		d.TTL = v
+	case expOk:
+		v, err := ParseTimeValue(expiration)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", Expiration, err)
+		}
+		d.Expiration = v
+	}
+
+	if v, ok := params.Get(Priority); ok && strings.TrimSpace(valueString(v)) != "" {
+		p, err := strconv.Atoi(strings.TrimSpace(valueString(v)))
+		if err != nil || !ValidPriority(p) {
+			return nil, fmt.Errorf("invalid %s %q, expected 1, 5 or 10", Priority, valueString(v))
+		}
+		d.Priority = p
+	} else if background {
+		d.Priority = PriorityLow
+	} else {
+		d.Priority = system.DefaultPriority
+	}
+	if background && d.Priority > PriorityLow {
+		return nil, fmt.Errorf("background pushes must use %s 1 or 5", Priority)
+	}
+
+	level := PMGet(params, Level)
+	if level == "" {
+		level = system.DefaultLevel
+	}
+	if level == "" {
+		level = LevelDefault
+	}
+	var ok bool
+	if d.Level, ok = NormalizeLevel(level); !ok {
+		return nil, fmt.Errorf("invalid %s %q, expected passive, active, timeSensitive or critical", Level, level)
+	}
+
+	if v, ok := params.Get(RelevanceScore); ok && strings.TrimSpace(valueString(v)) != "" {
+		score, err := strconv.ParseFloat(strings.TrimSpace(valueString(v)), 64)
+		if err != nil || score < 0 || score > 1 {
+			return nil, fmt.Errorf("invalid %s %q, expected a number between 0 and 1", RelevanceScore, valueString(v))
+		}
+		d.RelevanceScore = &score
+	}
+
+	if v, ok := params.Get(Volume); ok && strings.TrimSpace(valueString(v)) != "" {
+		volume, err := strconv.ParseFloat(strings.TrimSpace(valueString(v)), 64)
+		if err != nil || volume < 0 || volume > 1 {
+			return nil, fmt.Errorf("invalid %s %q, expected a number between 0 and 1", Volume, valueString(v))
+		}
+		d.Volume = volume
+	}
+
+	return d, nil
+}
+
+// checkDelivery 校验投递选项，并把 level 统一为 App 使用的写法
+// 指定的过期时间不能早于发送时间
+func checkDelivery(main *ParamsResult) error {
+	background := main.PushType == 0 && main.LiveActivity == nil
+	d, err := ParseDelivery(main.Params, background)
+	if err != nil {
+		return err
+	}
+	main.Params.Set(Level, d.Level)
+
+	if !d.Expiration.IsZero() {
+		sendAt := main.SendAt
+		if sendAt.IsZero() {
+			sendAt = DateNow()
+		}
+		if !d.Expiration.After(sendAt) {
+			return fmt.Errorf("%s must be later than the send time", Expiration)
+		}
+		if d.Expiration.Sub(sendAt) > MaxTTL {
+			return fmt.Errorf("%s must be within %s of the send time", Expiration, MaxTTL)
+		}
+	}
+	return nil
+}
+
+// NormalizeLevel 统一打断级别的写法，支持 time-sensitive / timesensitive
+func NormalizeLevel(level string) (string, bool) {
+	switch strings.ToLower(strings.ReplaceAll(strings.TrimSpace(level), "-", "")) {
+	case "passive":
+		return LevelPassive, true
+	case "active":
+		return LevelActive, true
+	case "timesensitive":
+		return LevelTimeSensitive, true
+	case "critical":
+		return LevelCritical, true
+	}
+	return "", false
+}
+
+// ValidPriority APNs 只接受 1、5、10 三个优先级
+func ValidPriority(p int) bool {
+	return p == PriorityConserve || p == PriorityLow || p == PriorityImmediate
+}
+
+// CheckDeliveryDefaults 校验 system 中的投递默认值
+func CheckDeliveryDefaults() error {
+	system := LocalConfig.System
+	if system.DefaultTTL < 0 || system.DefaultTTL > MaxTTL {
+		return fmt.Errorf("default_ttl must be between 0 and %s", MaxTTL)
+	}
+	if system.DefaultPriority != 0 && !ValidPriority(system.DefaultPriority) {
+		return fmt.Errorf("default_priority must be 0, 1, 5 or 10")
+	}
+	if _, ok := NormalizeLevel(system.DefaultLevel); !ok {
+		return fmt.Errorf("default_level must be passive, active, timeSensitive or critical")
+	}
+	if _, ok := NormalizeLongMessage(system.LongMessage); !ok {
+		return fmt.Errorf("long_message must be %s or %s", LongMessageSplit, LongMessageStore)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// eciesInfo HKDF 的 info，客户端解密时需要使用相同的值
+	eciesInfo = "NoLets E2E v1"
+	// eciesNonceSize AES-GCM 的 nonce 长度
+	eciesNonceSize = 12
+	// eciesTagSize AES-GCM 的 tag 长度
+	eciesTagSize = 16
+)
+
+// Encrypted 请求是否要求服务端使用设备公钥加密推送内容
+func Encrypted(params *ParamsMap) bool {
+	switch strings.ToLower(PMGet(params, Encrypt)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// ParseDevicePublicKey 解析设备注册的公钥，支持 base64 编码的
+// X25519 原始公钥 (32 字节)、P-256 未压缩公钥 (65 字节) 或 SPKI DER
+func ParseDevicePublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		if raw, err = decodeBase64URL(strings.TrimSpace(s)); err != nil {
+			return nil, errors.New("public key must be base64 encoded")
+		}
+	}
+
+	switch len(raw) {
+	case 32:
+		return ecdh.X25519().NewPublicKey(raw)
+	case 65:
+		return ecdh.P256().NewPublicKey(raw)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(raw)
+	if err != nil {
+		return nil, errors.New("unsupported public key, expected X25519 or P-256")
+	}
+	switch key := parsed.(type) {
+	case *ecdh.PublicKey:
+		if key.Curve() == ecdh.X25519() {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if key, err := key.ECDH(); err == nil && key.Curve() == ecdh.P256() {
+			return key, nil
+		}
+	}
+	return nil, errors.New("unsupported public key, expected X25519 or P-256")
+}
+
+// NormalizeDevicePublicKey 校验公钥并统一保存为原始公钥的 base64
+func NormalizeDevicePublicKey(s string) (string, error) {
+	key, err := ParseDevicePublicKey(s)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()), nil
+}
+
+// SealForDevice 使用设备公钥 ECIES 加密，结果为 base64(临时公钥 || nonce || 密文 || tag)
+// 密钥为 HKDF-SHA256(共享密钥, salt = 临时公钥 || 设备公钥, info = "NoLets E2E v1") 的 32 字节，
+// 使用 AES-256-GCM 加密，客户端可以直接用 CryptoKit 解密
+func SealForDevice(publicKey string, plaintext []byte) (string, error) {
+	recipient, err := ParseDevicePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	gcm, err := eciesCipher(secret, ephemeralPublic, recipient.Bytes())
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, eciesNonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := make([]byte, 0, len(ephemeralPublic)+eciesNonceSize+len(plaintext)+eciesTagSize)
+	sealed = append(sealed, ephemeralPublic...)
+	sealed = append(sealed, nonce...)
+	sealed = gcm.Seal(sealed, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenForDevice 使用设备私钥解密 SealForDevice 的结果，与客户端的解密过程相同
+func OpenForDevice(private *ecdh.PrivateKey, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	keySize := len(private.PublicKey().Bytes())
+	if len(data) < keySize+eciesNonceSize+eciesTagSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	ephemeral, err := private.Curve().NewPublicKey(data[:keySize])
+	if err != nil {
+		return nil, err
+	}
+	secret, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := eciesCipher(secret, data[:keySize], private.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce := data[keySize : keySize+eciesNonceSize]
+	return gcm.Open(nil, nonce, data[keySize+eciesNonceSize:], nil)
+}
+
+// SealedSize 明文加密并 base64 编码后的长度，用于拆分过长的消息
+func SealedSize(n int) int {
+	// 临时公钥按 P-256 的 65 字节计算
+	return base64.StdEncoding.EncodedLen(65 + eciesNonceSize + n + eciesTagSize)
+}
+
+// SealParams 把 title / subtitle / body 加密到 ciphertext 中，返回新的参数，不修改 params
+// 长消息的 messagetoken 同样加密，推送通道无法用它获取完整内容
+func SealParams(params *ParamsMap, publicKey string) (*ParamsMap, error) {
+	if publicKey == "" {
+		return nil, errors.New("device has no public key registered")
+	}
+
+	content := map[string]string{}
+	for _, key := range []string{Title, Subtitle, Body, MessageToken} {
+		if value := PMGet(params, key); value != "" {
+			content[key] = value
+		}
+	}
+	// 不转义 <>&，避免加密后的长度超出拆分时的估算
+	var plaintext bytes.Buffer
+	encoder := json.NewEncoder(&plaintext)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(content); err != nil {
+		return nil, err
+	}
+	sealed, err := SealForDevice(publicKey, bytes.TrimSpace(plaintext.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %v", err)
+	}
+
+	result := copyPayload(params)
+	result.Delete(Title)
+	result.Delete(Subtitle)
+	result.Delete(Body)
+	result.Delete(MessageToken)
+	result.Set(CipherText, sealed)
+	return result, nil
+}
+
+// checkEncrypt 服务端加密不能与客户端自行加密的 ciphertext 或实时活动同时使用
+func checkEncrypt(main *ParamsResult) error {
+	if !Encrypted(main.Params) {
+		return nil
+	}
+	if PMGet(main.Params, CipherText) != "" {
+		return fmt.Errorf("%s and %s cannot be used together", Encrypt, CipherText)
+	}
+	if main.LiveActivity != nil {
+		return fmt.Errorf("%s is not supported for live activities", Encrypt)
+	}
+	return nil
+}
+
+// eciesCipher 由 ECDH 共享密钥派生 AES-256-GCM 密钥
+func eciesCipher(secret, ephemeralPublic, recipientPublic []byte) (cipher.AEAD, error) {
+	salt := append(append(make([]byte, 0, len(ephemeralPublic)+len(recipientPublic)), ephemeralPublic...), recipientPublic...)
+	prk := hkdfExtract(salt, secret)
+	block, err := aes.NewCipher(hkdfExpand(prk, []byte(eciesInfo), 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
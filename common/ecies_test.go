@@ -0,0 +1,153 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/wk8/go-ordered-map/v2"
+)
+
+// deviceKeys 设备支持的两种曲线
+var deviceKeys = map[string]ecdh.Curve{
+	"X25519": ecdh.X25519(),
+	"P-256":  ecdh.P256(),
+}
+
+func newDeviceKey(t *testing.T, curve ecdh.Curve) (*ecdh.PrivateKey, string) {
+	t.Helper()
+	private, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := NormalizeDevicePublicKey(base64.StdEncoding.EncodeToString(private.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, publicKey
+}
+
+func TestSealForDeviceRoundTrip(t *testing.T) {
+	for name, curve := range deviceKeys {
+		t.Run(name, func(t *testing.T) {
+			private, publicKey := newDeviceKey(t, curve)
+			plaintext := []byte(`{"title":"标题","body":"hello <world> & \"you\""}`)
+
+			sealed, err := SealForDevice(publicKey, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sealed) > SealedSize(len(plaintext)) {
+				t.Fatalf("sealed size %d exceeds estimate %d", len(sealed), SealedSize(len(plaintext)))
+			}
+
+			opened, err := OpenForDevice(private, sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Fatalf("expected %q, got %q", plaintext, opened)
+			}
+
+			// 其他设备的私钥无法解密
+			other, _ := newDeviceKey(t, curve)
+			if _, err = OpenForDevice(other, sealed); err == nil {
+				t.Fatal("expected decryption with another key to fail")
+			}
+		})
+	}
+}
+
+func TestSealParamsRoundTrip(t *testing.T) {
+	for name, curve := range deviceKeys {
+		t.Run(name, func(t *testing.T) {
+			private, publicKey := newDeviceKey(t, curve)
+			params := orderedmap.New[string, interface{}]()
+			params.Set(Title, "title")
+			params.Set(Subtitle, "subtitle")
+			params.Set(Body, "body")
+			params.Set(Group, "ops")
+
+			sealed, err := SealParams(params, publicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{Title, Subtitle, Body} {
+				if _, ok := sealed.Get(key); ok {
+					t.Fatalf("%s should be removed from sealed params", key)
+				}
+			}
+			if PMGet(sealed, Group) != "ops" || PMGet(params, Body) != "body" {
+				t.Fatal("SealParams should keep other params and not modify the original")
+			}
+
+			opened, err := OpenForDevice(private, PMGet(sealed, CipherText))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var content map[string]string
+			if err = json.Unmarshal(opened, &content); err != nil {
+				t.Fatal(err)
+			}
+			if content[Title] != "title" || content[Subtitle] != "subtitle" || content[Body] != "body" {
+				t.Fatalf("unexpected content: %v", content)
+			}
+		})
+	}
+}
+
+func TestEncryptedSplitStaysUnderMaxBytes(t *testing.T) {
+	for name, curve := range deviceKeys {
+		t.Run(name, func(t *testing.T) {
+			private, publicKey := newDeviceKey(t, curve)
+			body := strings.Repeat("推送内容 push body <&> ", 400)
+
+			params := orderedmap.New[string, interface{}]()
+			params.Set(Title, "encrypted title")
+			params.Set(Subtitle, "subtitle")
+			params.Set(Body, body)
+			params.Set(Encrypt, "1")
+			params.Set(Group, "ops")
+
+			parts, err := SplitPayloadIfExceedsLimit(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) < 2 {
+				t.Fatalf("expected body to be split, got %d parts", len(parts))
+			}
+
+			var joined strings.Builder
+			for i, part := range parts {
+				sealed, err := SealParams(part, publicKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := json.Marshal(orderedToMap(sealed))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(data) > MaxBytes {
+					t.Fatalf("part %d is %d bytes, exceeds %d", i, len(data), MaxBytes)
+				}
+
+				opened, err := OpenForDevice(private, PMGet(sealed, CipherText))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var content map[string]string
+				if err = json.Unmarshal(opened, &content); err != nil {
+					t.Fatal(err)
+				}
+				joined.WriteString(content[Body])
+			}
+			if joined.String() != body {
+				t.Fatal("decrypted parts do not add up to the original body")
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// FCMHost FCM HTTP v1 接口地址
+	FCMHost = "https://fcm.googleapis.com"
+	// FCMScope 发送 FCM 消息需要的 OAuth2 权限
+	FCMScope = "https://www.googleapis.com/auth/firebase.messaging"
+	// googleTokenURI 服务账号未配置 token_uri 时使用的地址
+	googleTokenURI = "https://oauth2.googleapis.com/token"
+)
+
+// ServiceAccount Firebase 服务账号 JSON 中用到的字段
+type ServiceAccount struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+// ReadServiceAccount 读取并解析服务账号文件
+func ReadServiceAccount(path string) (*ServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var account ServiceAccount
+	if err = json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account: %v", err)
+	}
+	if account.Type != "" && account.Type != "service_account" {
+		return nil, fmt.Errorf("invalid service account type: %s", account.Type)
+	}
+	if account.ClientEmail == "" {
+		return nil, errors.New("invalid service account: missing client_email")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = googleTokenURI
+	}
+
+	if account.key, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey)); err != nil {
+		return nil, fmt.Errorf("invalid service account private key: %v", err)
+	}
+	return &account, nil
+}
+
+// Key 返回服务账号的 RSA 私钥
+func (a *ServiceAccount) Key() *rsa.PrivateKey {
+	return a.key
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxIdempotencyKeyLength 幂等 key 的最大长度
+const maxIdempotencyKeyLength = 255
+
+// IdempotentResult 保存的幂等推送结果，窗口期内相同 key 的请求直接返回 Response
+type IdempotentResult struct {
+	ID         string          `json:"id"`
+	Response   json.RawMessage `json:"response"`
+	CreateDate time.Time       `json:"createDate"`
+	ExpiresAt  time.Time       `json:"expiresAt"`
+}
+
+// IdempotencyScope 返回请求的幂等记录 ID，未提供幂等 key 或未启用时返回空字符串
+// 记录按设备 key 和 token 区分，不同调用方使用相同的幂等 key 不会互相影响
+func IdempotencyScope(header string, params *ParamsResult) (string, error) {
+	if LocalConfig.System.IdempotencyWindow <= 0 {
+		return "", nil
+	}
+
+	key := strings.TrimSpace(header)
+	if key == "" {
+		key = strings.TrimSpace(PMGet(params.Params, IdempotencyKey))
+	}
+	if key == "" {
+		return "", nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return "", errors.New("idempotency key is too long")
+	}
+
+	targets := slices.Clone(params.Keys)
+	for _, token := range params.Tokens {
+		targets = append(targets, token.Token)
+	}
+	slices.Sort(targets)
+
+	sum := sha256.Sum256([]byte(key + "\n" + strings.Join(targets, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MaxImageSize 上传图片的最大字节数
+const MaxImageSize = 10 << 20
+
+// ImageDirName 数据目录中保存上传图片的子目录
+const ImageDirName = "images"
+
+// imageNamePattern 图片名称只允许字母、数字、点、下划线和连字符，不能以点开头
+var imageNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$`)
+
+// imageTypes 允许上传的图片类型及保存时使用的扩展名
+var imageTypes = map[string]string{
+	MIMEImagePng:  ".png",
+	MIMEImageJpeg: ".jpg",
+	MIMEImageGif:  ".gif",
+	MIMEImageWebp: ".webp",
+	MIMEImageHeic: ".heic",
+}
+
+// heicBrands ftyp 中表示 HEIF / HEIC 图片的品牌
+var heicBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1"}
+
+// ImageDir 上传图片的保存目录
+func ImageDir() string {
+	return BaseDir(ImageDirName)
+}
+
+// DetectImageType 根据文件内容判断图片类型，返回 MIME 类型和扩展名，不信任上传时的 Content-Type
+func DetectImageType(head []byte) (string, string, bool) {
+	mime := http.DetectContentType(head)
+	if ext, ok := imageTypes[mime]; ok {
+		return mime, ext, true
+	}
+
+	// DetectContentType 不识别 HEIC，检查 ISO BMFF 的 ftyp 盒子
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		for _, brand := range heicBrands {
+			if string(head[8:12]) == brand {
+				return MIMEImageHeic, imageTypes[MIMEImageHeic], true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// ImageContentType 按扩展名返回已保存图片的 MIME 类型
+func ImageContentType(name string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".jpeg" {
+		return MIMEImageJpeg, true
+	}
+	for mime, e := range imageTypes {
+		if e == ext {
+			return mime, true
+		}
+	}
+	return "", false
+}
+
+// ValidImageName 校验图片名称，防止访问图片目录之外的文件
+func ValidImageName(name string) bool {
+	return imageNamePattern.MatchString(name) && !strings.Contains(name, "..")
+}
+
+// ImageFileName 使用识别出的扩展名生成保存的文件名，name 中原有的图片扩展名会被替换
+func ImageFileName(name, ext string) string {
+	if _, ok := ImageContentType(name); ok {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name + ext
+}
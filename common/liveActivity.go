@@ -0,0 +1,100 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LiveActivity 实时活动推送的参数，params 中带有 event 时启用
+type LiveActivity struct {
+	Event          string                 `json:"event"`
+	ContentState   map[string]interface{} `json:"contentState,omitempty"`
+	AttributesType string                 `json:"attributesType,omitempty"`
+	Attributes     map[string]interface{} `json:"attributes,omitempty"`
+	StaleDate      time.Time              `json:"staleDate,omitempty"`
+	DismissalDate  time.Time              `json:"dismissalDate,omitempty"`
+	ActivityID     string                 `json:"activityId,omitempty"`
+}
+
+// LiveActivityToken 实时活动 token
+// start 类型为 push-to-start token，用于远程启动实时活动
+// update 类型为单个实时活动的 token，用于更新和结束该活动
+type LiveActivityToken struct {
+	TokenInfo
+	Type           string `json:"type"`
+	ActivityID     string `json:"activityId,omitempty"`
+	AttributesType string `json:"attributesType,omitempty"`
+}
+
+// ParseLiveActivity 从推送参数中解析实时活动，没有 event 参数时返回 nil
+func ParseLiveActivity(params *ParamsMap) (*LiveActivity, error) {
+	event, ok := params.Get(Event)
+	if !ok || strings.TrimSpace(valueString(event)) == "" {
+		return nil, nil
+	}
+
+	la := &LiveActivity{
+		Event:          strings.ToLower(strings.TrimSpace(valueString(event))),
+		AttributesType: strings.TrimSpace(PMGet(params, AttributesType)),
+		ActivityID:     strings.TrimSpace(PMGet(params, ActivityID)),
+	}
+
+	var err error
+	if la.ContentState, err = jsonObject(params, ContentState); err != nil {
+		return nil, err
+	}
+	if la.Attributes, err = jsonObject(params, Attributes); err != nil {
+		return nil, err
+	}
+	if v, ok := params.Get(StaleDate); ok && valueString(v) != "" {
+		if la.StaleDate, err = ParseTimeValue(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", StaleDate, err)
+		}
+	}
+	if v, ok := params.Get(DismissalDate); ok && valueString(v) != "" {
+		if la.DismissalDate, err = ParseTimeValue(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", DismissalDate, err)
+		}
+	}
+
+	switch la.Event {
+	case LiveActivityStart:
+		if la.AttributesType == "" || la.Attributes == nil {
+			return nil, fmt.Errorf("%s and %s are required to start a live activity", AttributesType, Attributes)
+		}
+		if la.ContentState == nil {
+			return nil, fmt.Errorf("%s is required to start a live activity", ContentState)
+		}
+	case LiveActivityUpdate:
+		if la.ContentState == nil {
+			return nil, fmt.Errorf("%s is required to update a live activity", ContentState)
+		}
+	case LiveActivityEnd:
+	default:
+		return nil, fmt.Errorf("invalid %s %q, expected start, update or end", Event, la.Event)
+	}
+	return la, nil
+}
+
+// jsonObject 读取 JSON 对象参数，可以是 JSON 请求体中的对象，也可以是查询参数中的 JSON 字符串
+func jsonObject(params *ParamsMap, key string) (map[string]interface{}, error) {
+	v, ok := params.Get(key)
+	if !ok || v == nil {
+		return nil, nil
+	}
+	if obj, ok := v.(map[string]interface{}); ok {
+		return obj, nil
+	}
+
+	s := strings.TrimSpace(valueString(v))
+	if s == "" {
+		return nil, nil
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &obj); err != nil {
+		return nil, fmt.Errorf("%s must be a JSON object", key)
+	}
+	return obj, nil
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 长消息的发送方式
+const (
+	LongMessageSplit = "split" // 拆分为多条推送
+	LongMessageStore = "store" // 保存在服务端，只推送预览
+)
+
+// previewReserve 预览内容为 messageid / messagetoken 预留的字节数
+const previewReserve = 128
+
+// StoredMessage 保存在服务端的完整长消息，App 收到预览后通过 GET /message/:id 获取
+type StoredMessage struct {
+	ID         string    `json:"id"`
+	Token      string    `json:"token"` // 获取消息时需要提供，只发送给接收的设备
+	Title      string    `json:"title,omitempty"`
+	Subtitle   string    `json:"subtitle,omitempty"`
+	Body       string    `json:"body"`
+	Keys       []string  `json:"keys,omitempty"`
+	CreateDate time.Time `json:"createDate"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// LongMessageMode 返回请求指定的长消息发送方式，未指定时使用 system.long_message
+func LongMessageMode(params *ParamsMap) string {
+	if mode, ok := NormalizeLongMessage(PMGet(params, LongMessage)); ok && mode != "" {
+		return mode
+	}
+	if mode, ok := NormalizeLongMessage(LocalConfig.System.LongMessage); ok && mode != "" {
+		return mode
+	}
+	return LongMessageSplit
+}
+
+// NormalizeLongMessage 规范化长消息发送方式，为空时返回空字符串
+func NormalizeLongMessage(mode string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "":
+		return "", true
+	case LongMessageSplit:
+		return LongMessageSplit, true
+	case LongMessageStore:
+		return LongMessageStore, true
+	}
+	return "", false
+}
+
+// checkLongMessage 校验请求中的 longmessage 参数
+func checkLongMessage(main *ParamsResult) error {
+	if _, ok := NormalizeLongMessage(PMGet(main.Params, LongMessage)); !ok {
+		return fmt.Errorf("%s must be %s or %s", LongMessage, LongMessageSplit, LongMessageStore)
+	}
+	return nil
+}
+
+// MessagePreview 生成长消息的预览推送，body 截取为 first 并附带获取完整内容的 id 和 token
+// first 为拆分后的第一段内容
+func MessagePreview(params *ParamsMap, first string, msg *StoredMessage) *ParamsMap {
+	preview := copyPayload(params)
+	if chunks := splitByUTF8Bytes(first, max(len(first)-previewReserve, 1)); len(chunks) > 0 {
+		first = chunks[0]
+	}
+	preview.Set(Body, first+"…")
+	preview.Set(MessageID, msg.ID)
+	preview.Set(MessageToken, msg.Token)
+	return preview
+}
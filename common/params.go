@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
@@ -20,11 +24,14 @@ type ParamsMap = orderedmap.OrderedMap[string, interface{}]
 // ParamsResult 结构体用于存储和管理请求参数
 // 使用有序映射存储参数，保证参数的处理顺序
 type ParamsResult struct {
-	Params   *ParamsMap
-	Results  []*ParamsMap
-	Tokens   []string
-	Keys     []string
-	PushType int
+	Params       *ParamsMap
+	Results      []*ParamsMap
+	Tokens       []TokenInfo
+	Keys         []string
+	PushType     int
+	SendAt       time.Time     // 定时推送时间，零值表示立即发送
+	LiveActivity *LiveActivity `json:"liveActivity,omitempty"` // 带有 event 参数时为实时活动推送
+	Err          error         `json:"-"`                      // 参数校验失败的原因
 }
 
 // NewParamsResult 创建新的参数结果对象
@@ -34,41 +41,100 @@ type ParamsResult struct {
 // 返回:
 //   - *ParamsResult: 初始化后的参数结果对象
 func NewParamsResult(c *gin.Context) *ParamsResult {
-	main := &ParamsResult{
+	main := newParamsResult()
+	main.HandlerParamsToMapOrder(c)
+	return buildParamsResult(main)
+}
+
+// NewParamsResultFromMap 使用保存下来的参数创建参数结果对象
+// 用于周期任务等不在请求上下文中发起的推送
+func NewParamsResultFromMap(params map[string]interface{}) *ParamsResult {
+	main := newParamsResult()
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := orderedmap.New[string, interface{}]()
+	for _, k := range keys {
+		result.Set(main.NormalizeKey(k), params[k])
+	}
+	convenientProcessor(result)
+
+	for pair := result.Oldest(); pair != nil; pair = pair.Next() {
+		main.Params.Set(pair.Key, pair.Value)
+	}
+	return buildParamsResult(main)
+}
+
+// WithoutSchedule 返回去掉 sendat 和 delay 的参数副本
+// 周期任务按 cron 表达式触发，保留定时参数会让每次触发都被延后或因时间已过而失败
+func WithoutSchedule(params map[string]interface{}) map[string]interface{} {
+	return withoutParams(params, SendAt, Delay)
+}
+
+// WithoutTargets 返回去掉设备 key 和 token 的参数副本，用于向非管理员展示多个设备共用的任务
+func WithoutTargets(params map[string]interface{}) map[string]interface{} {
+	return withoutParams(params, DeviceKey, DeviceKeys, DeviceToken)
+}
+
+// withoutParams 返回去掉指定参数的副本，参数名按 NormalizeKey 规范化后比较
+func withoutParams(params map[string]interface{}, names ...string) map[string]interface{} {
+	main := newParamsResult()
+	result := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if slices.Contains(names, main.NormalizeKey(k)) {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+func newParamsResult() *ParamsResult {
+	return &ParamsResult{
 		Params:  orderedmap.New[string, interface{}](),
 		Results: []*ParamsMap{},
 		Keys:    []string{},
-		Tokens:  []string{},
+		Tokens:  []TokenInfo{},
 	}
-	main.HandlerParamsToMapOrder(c)
+}
+
+// buildParamsResult 校验参数并拆分出设备 key、token 和推送内容
+func buildParamsResult(main *ParamsResult) *ParamsResult {
+	// 模版渲染出标题和内容后才能判断推送类型
+	if main.Err = applyTemplate(main.Params); main.Err != nil {
+		return main
+	}
+
 	main.PushType = ParamsNanAndDefault(main)
 
 	if main.PushType == -1 {
 		return nil
 	}
 
-	results, err := SplitPayloadIfExceedsLimit(main.Params)
-	if err == nil {
-		main.Results = results
+	main.SendAt, main.Err = ParseSendAt(main.Params)
+	if main.Err == nil {
+		main.LiveActivity, main.Err = ParseLiveActivity(main.Params)
 	}
-
-	var resultKeys []string
-
-	if keys, ok := main.Params.Get(DeviceKeys); ok {
-		if vals, oka := keys.([]string); oka {
-			resultKeys = vals
-		}
+	if main.Err == nil {
+		main.Err = checkDelivery(main)
+	}
+	if main.Err == nil {
+		main.Err = checkEncrypt(main)
+	}
+	if main.Err == nil {
+		main.Err = checkLongMessage(main)
 	}
 
-	if key, ok := main.Params.Get(DeviceKey); ok {
-		if val, oka := key.(string); oka {
-			resultKeys = append(resultKeys, val)
-		}
-
+	results, err := SplitPayloadIfExceedsLimit(main.Params)
+	if err == nil {
+		main.Results = results
 	}
 
-	resultKeys = FilterShortStrings(resultKeys, 5, 64)
-	main.Keys = Unique[string](resultKeys)
+	main.Keys = paramsDeviceKeys(main.Params)
 
 	if len(main.Keys) > LocalConfig.System.MaxDeviceKeyArrLength {
 		main.Keys = main.Keys[:LocalConfig.System.MaxDeviceKeyArrLength]
@@ -81,13 +147,49 @@ func NewParamsResult(c *gin.Context) *ParamsResult {
 		}
 	}
 
-	tokens = FilterShortStrings(tokens, 60, 65)
+	// 实时活动的 token 比设备 token 更长
+	if main.LiveActivity != nil {
+		tokens = FilterShortStrings(tokens, 60, 512)
+	} else {
+		tokens = FilterShortStrings(tokens, 60, 65)
+	}
 
-	main.Tokens = tokens
+	// 直接指定 token 推送时使用服务端默认环境
+	for _, token := range tokens {
+		main.Tokens = append(main.Tokens, TokenInfo{Token: token})
+	}
 
 	return main
 }
 
+// RequestDeviceKeys 返回请求参数中的设备 key，供中间件在推送处理前使用
+func RequestDeviceKeys(c *gin.Context) []string {
+	p := newParamsResult()
+	p.HandlerParamsToMapOrder(c)
+	return paramsDeviceKeys(p.Params)
+}
+
+// paramsDeviceKeys 合并 devicekeys 和 devicekey 参数并去重
+func paramsDeviceKeys(params *ParamsMap) []string {
+	var resultKeys []string
+
+	if keys, ok := params.Get(DeviceKeys); ok {
+		if vals, oka := keys.([]string); oka {
+			resultKeys = vals
+		}
+	}
+
+	if key, ok := params.Get(DeviceKey); ok {
+		if val, oka := key.(string); oka {
+			resultKeys = append(resultKeys, val)
+		}
+
+	}
+
+	resultKeys = FilterShortStrings(resultKeys, 5, 64)
+	return Unique[string](resultKeys)
+}
+
 // NormalizeKey 规范化参数键名
 // 主要功能:
 // 1. 去除首尾空格
@@ -296,14 +398,16 @@ func ParamsNanAndDefault(paramsResult *ParamsResult) (resultType int) {
 	body, bodyOk := paramsResult.Params.Get(Body)
 	cipherText, cipherTextOk := paramsResult.Params.Get(CipherText)
 	id, idOK := paramsResult.Params.Get(ID)
+	event, eventOk := paramsResult.Params.Get(Event)
 
 	titleNan := !titleOk || isEmpty(title)
 	subTitleNan := !subTitleOk || isEmpty(subTitle)
 	bodyNan := !bodyOk || isEmpty(body)
 	cipherNan := !cipherTextOk || isEmpty(cipherText)
 	idNan := !idOK || isEmpty(id)
+	eventNan := !eventOk || isEmpty(event)
 
-	if titleNan && subTitleNan && bodyNan && cipherNan && idNan {
+	if titleNan && subTitleNan && bodyNan && cipherNan && idNan && eventNan {
 		resultType = -1
 		return
 	}
@@ -335,7 +439,7 @@ func ParamsNanAndDefault(paramsResult *ParamsResult) (resultType int) {
 
 	// 设置消息级别的默认值
 	setDefault(paramsResult.Params, Level, func(key string, value interface{}) {
-		paramsResult.Params.Set(key, LevelDefault)
+		paramsResult.Params.Set(key, LocalConfig.System.DefaultLevel)
 	})
 
 	// 设置消息分类的默认值
@@ -368,14 +472,31 @@ func SplitPayloadIfExceedsLimit(basePayload *ParamsMap) ([]*ParamsMap, error) {
 
 	baseJson, _ := json.Marshal(orderedToMap(base))
 	baseSize := len(baseJson)
+	bodySize := len([]byte(bodyStr))
+
+	// 服务端加密时 title / subtitle / body 一起加密到 ciphertext，按加密后的长度计算
+	encrypted := Encrypted(basePayload)
+	if encrypted {
+		plain := copyPayload(base)
+		plain.Delete(Title)
+		plain.Delete(Subtitle)
+		plainJson, _ := json.Marshal(orderedToMap(plain))
+		content, _ := json.Marshal(map[string]string{Title: PMGet(base, Title), Subtitle: PMGet(base, Subtitle), Body: ""})
+		baseSize = len(plainJson) + len(`,"":""`) + len(CipherText) + SealedSize(len(content))
+		bodySize = SealedSize(len(content)+bodySize) - SealedSize(len(content))
+	}
 
 	// 如果总大小不超 4096，直接返回原始 payload
-	if baseSize+len([]byte(bodyStr)) <= MaxBytes {
+	if baseSize+bodySize <= MaxBytes {
 		return []*ParamsMap{}, nil
 	}
 
 	// 需要分片
 	remaining := MaxBytes - baseSize - 100 // 留余：考虑 index/count/body key等
+	if encrypted {
+		// base64 编码后长度为原来的 4/3
+		remaining = remaining * 3 / 4
+	}
 	if remaining <= 0 {
 		return nil, errors.New("base payload too large without body")
 	}
@@ -441,3 +562,96 @@ func splitByUTF8Bytes(s string, maxBytes int) []string {
 
 	return result
 }
+
+// MaxScheduleDelay 定时推送最远允许的时间
+const MaxScheduleDelay = 365 * 24 * time.Hour
+
+// ParseSendAt 解析 sendat / delay 参数，返回消息的发送时间，零值表示立即发送
+// sendat 支持 RFC3339、unix 时间戳（秒或毫秒）以及 system.time_zone 时区下的 "2006-01-02 15:04:05"
+// delay 支持 Go duration 格式（如 90m、2h30m）或秒数
+// 两个参数都会从 params 中移除，不会出现在推送内容里
+func ParseSendAt(params *ParamsMap) (time.Time, error) {
+	sendAt, sendAtOk := params.Get(SendAt)
+	delay, delayOk := params.Get(Delay)
+	params.Delete(SendAt)
+	params.Delete(Delay)
+
+	var result time.Time
+	switch {
+	case sendAtOk && len(strings.TrimSpace(valueString(sendAt))) > 0:
+		t, err := ParseTimeValue(sendAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", SendAt, err)
+		}
+		result = t
+	case delayOk && len(strings.TrimSpace(valueString(delay))) > 0:
+		d, err := ParseDurationValue(delay)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", Delay, err)
+		}
+		result = DateNow().Add(d)
+	default:
+		return time.Time{}, nil
+	}
+
+	if result.Sub(DateNow()) > MaxScheduleDelay {
+		return time.Time{}, fmt.Errorf("send time must be within %s", MaxScheduleDelay)
+	}
+	return result.UTC(), nil
+}
+
+// ParseTimeValue 解析时间参数，支持 RFC3339、unix 时间戳（秒或毫秒）
+// 以及不带时区的 "2006-01-02 15:04:05"（按 system.time_zone 解析）
+func ParseTimeValue(v interface{}) (time.Time, error) {
+	s := strings.TrimSpace(valueString(v))
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, LocalLocation()); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// ParseDurationValue 解析时长参数，支持 Go duration 格式或秒数
+func ParseDurationValue(v interface{}) (time.Duration, error) {
+	s := strings.TrimSpace(valueString(v))
+
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("negative duration %q", s)
+		}
+		return time.Duration(n * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+	return d, nil
+}
+
+// valueString 将参数值转换为字符串，JSON 中的数字不使用科学计数法
+func valueString(v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case []string:
+		if len(val) > 0 {
+			return val[0]
+		}
+		return ""
+	}
+	return fmt.Sprint(v)
+}
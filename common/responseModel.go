@@ -56,8 +56,30 @@ func BaseRes(code int, message string, data ...interface{}) BaseResp {
 }
 
 type DeviceInfo struct {
-	Key   string `json:"key"`
-	Token string `json:"token"`
+	Key      string `json:"key"`
+	Token    string `json:"token"`
+	Name     string `json:"name,omitempty"`
+	Env      string `json:"env,omitempty"`
+	App      string `json:"app,omitempty"`
+	Platform string `json:"platform,omitempty"` // ios 或 android，为空时为 ios
+	// PublicKey 设备的 X25519 / P-256 公钥，base64 编码，用于 encrypt=1 时服务端加密
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// TokenInfo 设备 key 下的单个推送 token 及其元数据
+// Env 为空时使用服务端 apple.develop 配置的默认环境
+type TokenInfo struct {
+	Key      string `json:"key,omitempty"` // 查询时填充，用于推送结果中标明所属的 key
+	Token    string `json:"token"`
+	Name     string `json:"name,omitempty"`
+	Env      string `json:"env,omitempty"`
+	App      string `json:"app,omitempty"`      // 注册时选择的 App，为空时使用默认 App
+	Platform string `json:"platform,omitempty"` // 设备平台，为空时为 ios
+	// Subscription 浏览器的推送订阅，只有 web 平台有值，Token 为订阅的 ID
+	Subscription *WebPushSubscription `json:"subscription,omitempty"`
+	PublicKey    string               `json:"publicKey,omitempty"` // 设备公钥，encrypt=1 时使用
+	CreatedAt    time.Time            `json:"createdAt"`
+	UpdatedAt    time.Time            `json:"updatedAt"`
 }
 
 func DateNow() time.Time {
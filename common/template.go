@@ -0,0 +1,120 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateName 推送时指定模版的参数
+const TemplateName = "template"
+
+// templateNamePattern 模版名称只允许字母、数字、下划线和连字符
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// TemplateLookup 按名称读取保存的模版，由 push 包在启动时设置
+var TemplateLookup func(name string) (*Template, error)
+
+// Template 保存的推送模版，各字段使用 text/template 语法，渲染时可以引用请求中的其他参数
+type Template struct {
+	Name       string    `json:"name"`
+	Title      string    `json:"title,omitempty"`
+	Subtitle   string    `json:"subtitle,omitempty"`
+	Body       string    `json:"body,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Sound      string    `json:"sound,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	CreateDate time.Time `json:"createDate"`
+	UpdateDate time.Time `json:"updateDate"`
+}
+
+// fields 模版字段与推送参数的对应关系
+func (t *Template) fields() [][2]string {
+	return [][2]string{
+		{Title, t.Title},
+		{Subtitle, t.Subtitle},
+		{Body, t.Body},
+		{Group, t.Group},
+		{Sound, t.Sound},
+		{URL, t.URL},
+	}
+}
+
+// Check 校验模版名称和各字段的模版语法
+func (t *Template) Check() error {
+	if !templateNamePattern.MatchString(t.Name) {
+		return errors.New("invalid template name, expected 1-64 letters, digits, _ or -")
+	}
+	empty := true
+	for _, field := range t.fields() {
+		if field[1] == "" {
+			continue
+		}
+		empty = false
+		if _, err := parseTemplateField(field[0], field[1]); err != nil {
+			return fmt.Errorf("invalid %s template: %v", field[0], err)
+		}
+	}
+	if empty {
+		return errors.New("template is empty")
+	}
+	return nil
+}
+
+// Render 使用请求参数渲染模版，请求中已经指定的字段优先于模版
+// 缺少的参数渲染为空字符串
+func (t *Template) Render(params *ParamsMap) error {
+	data := make(map[string]string, params.Len())
+	for pair := params.Oldest(); pair != nil; pair = pair.Next() {
+		data[pair.Key] = fmt.Sprint(pair.Value)
+	}
+
+	for _, field := range t.fields() {
+		if field[1] == "" || strings.TrimSpace(PMGet(params, field[0])) != "" {
+			continue
+		}
+		tmpl, err := parseTemplateField(field[0], field[1])
+		if err != nil {
+			return fmt.Errorf("invalid %s template: %v", field[0], err)
+		}
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("failed to render %s: %v", field[0], err)
+		}
+		if buf.Len() > 0 {
+			params.Set(field[0], buf.String())
+		}
+	}
+	return nil
+}
+
+func parseTemplateField(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+// applyTemplate 带有 template 参数时读取模版并渲染到推送参数中
+func applyTemplate(params *ParamsMap) error {
+	name := strings.TrimSpace(PMGet(params, TemplateName))
+	if name == "" {
+		return nil
+	}
+	params.Delete(TemplateName)
+
+	if TemplateLookup == nil {
+		return errors.New("templates are not available")
+	}
+	tmpl, err := TemplateLookup(name)
+	if err != nil {
+		return fmt.Errorf("failed to load template %q: %v", name, err)
+	}
+	if err = tmpl.Render(params); err != nil {
+		return err
+	}
+	// 模版中的 sound 等字段同样需要规范化
+	convenientProcessor(params)
+	return nil
+}
@@ -0,0 +1,245 @@
+package common
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// WebPushRecordSize aes128gcm 的记录大小，整条消息只使用一个记录
+	WebPushRecordSize = 4096
+	// MaxWebPushPayload 加密前消息内容的最大长度，推送服务最多接受 4096 字节的消息体
+	MaxWebPushPayload = WebPushRecordSize - webPushHeaderSize - 16 - 1
+	// webPushHeaderSize salt(16) + rs(4) + idlen(1) + keyid(65)
+	webPushHeaderSize = 16 + 4 + 1 + 65
+	// webPushResolveTimeout 校验订阅时解析推送地址的超时时间
+	webPushResolveTimeout = 5 * time.Second
+)
+
+// nonPublicPrefixes IsPrivate 等方法没有覆盖的保留网段
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// WebPushSubscription 浏览器 PushManager.subscribe() 返回的订阅
+type WebPushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Check 校验推送地址和密钥，allowHTTP 为 false 时只接受 https 地址
+func (s *WebPushSubscription) Check(allowHTTP bool) error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid endpoint")
+	}
+	if u.Scheme != "https" && !(allowHTTP && u.Scheme == "http") {
+		return errors.New("endpoint must use https")
+	}
+	// 允许 http 时用于连接本地的 fake-apns，不限制地址
+	if !allowHTTP {
+		if err = checkEndpointHost(u.Hostname()); err != nil {
+			return err
+		}
+	}
+	if _, _, err = s.keys(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkEndpointHost 解析推送地址，拒绝指向回环、内网和链路本地等非公网地址的订阅
+func checkEndpointHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webPushResolveTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return errors.New("failed to resolve endpoint")
+	}
+	for _, addr := range addrs {
+		if !PublicAddr(addr) {
+			return errors.New("endpoint must be a public address")
+		}
+	}
+	return nil
+}
+
+// PublicAddr 判断地址是否为公网地址，推送服务只允许连接公网地址
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// ID 订阅的 token，推送地址可能超过数据库 token 字段的长度，使用其哈希
+func (s *WebPushSubscription) ID() string {
+	sum := sha256.Sum256([]byte(s.Endpoint))
+	return "web-" + hex.EncodeToString(sum[:20])
+}
+
+// keys 解码浏览器的公钥和 auth secret
+func (s *WebPushSubscription) keys() (*ecdh.PublicKey, []byte, error) {
+	p256dh, err := decodeBase64URL(s.Keys.P256dh)
+	if err != nil {
+		return nil, nil, errors.New("invalid p256dh key")
+	}
+	publicKey, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, nil, errors.New("invalid p256dh key")
+	}
+	auth, err := decodeBase64URL(s.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, nil, errors.New("invalid auth secret")
+	}
+	return publicKey, auth, nil
+}
+
+// EncryptWebPush 按 RFC 8291 使用 aes128gcm 加密消息内容
+func EncryptWebPush(sub *WebPushSubscription, plaintext []byte) ([]byte, error) {
+	if len(plaintext) > MaxWebPushPayload {
+		return nil, fmt.Errorf("web push payload too large: %d > %d", len(plaintext), MaxWebPushPayload)
+	}
+	uaPublic, auth, err := sub.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	asPublic := asPrivate.PublicKey().Bytes()
+	gcm, nonce, err := webPushCipher(secret, auth, salt, uaPublic.Bytes(), asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 0, webPushHeaderSize+len(plaintext)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, WebPushRecordSize)
+	body = append(body, byte(len(asPublic)))
+	body = append(body, asPublic...)
+	// 0x02 表示最后一个记录，不添加填充
+	record := append(append(make([]byte, 0, len(plaintext)+1), plaintext...), 0x02)
+	return gcm.Seal(body, nonce, record, nil), nil
+}
+
+// DecryptWebPush 使用浏览器的私钥和 auth secret 解密 aes128gcm 消息，用于本地模拟服务
+func DecryptWebPush(uaPrivate *ecdh.PrivateKey, auth, body []byte) ([]byte, error) {
+	if len(body) < webPushHeaderSize {
+		return nil, errors.New("web push body too short")
+	}
+	salt := body[:16]
+	idLen := int(body[20])
+	if idLen != 65 || len(body) < 21+idLen {
+		return nil, errors.New("invalid web push key id")
+	}
+	asPublicBytes := body[21 : 21+idLen]
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, nonce, err := webPushCipher(secret, auth, salt, uaPrivate.PublicKey().Bytes(), asPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// 去掉末尾的填充和记录分隔符
+	plaintext = []byte(strings.TrimRight(string(plaintext), "\x00"))
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		return nil, errors.New("invalid web push record delimiter")
+	}
+	return plaintext[:len(plaintext)-1], nil
+}
+
+// webPushCipher 按 RFC 8291 派生内容加密密钥和 nonce
+func webPushCipher(secret, auth, salt, uaPublic, asPublic []byte) (cipher.AEAD, []byte, error) {
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdfExpand(hkdfExtract(auth, secret), keyInfo, 32)
+
+	prk := hkdfExtract(salt, ikm)
+	cek := hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, nonce, nil
+}
+
+func hkdfExtract(salt, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand 只需要不超过一个哈希长度的输出
+func hkdfExpand(prk, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{0x01})
+	return mac.Sum(nil)[:length]
+}
+
+// decodeBase64URL 浏览器返回的密钥为 base64url，兼容带填充和标准 base64
+func decodeBase64URL(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return base64.RawURLEncoding.DecodeString(s)
+}
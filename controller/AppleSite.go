@@ -8,15 +8,17 @@ import (
 )
 
 func AppleSite(c *gin.Context) {
-	appID := fmt.Sprintf("%s.%s", common.LocalConfig.Apple.TeamID, common.LocalConfig.Apple.Topic)
+	var details []gin.H
+	for _, app := range common.AppleApps() {
+		details = append(details, gin.H{
+			"appID": fmt.Sprintf("%s.%s", app.TeamID, app.Topic),
+			"paths": []string{"*"},
+		})
+	}
+
 	c.JSON(200, gin.H{
 		"applinks": gin.H{
-			"details": []gin.H{
-				{
-					"appID": appID,
-					"paths": []string{"*"},
-				},
-			},
+			"details": details,
 		},
 	})
 }
@@ -1,13 +1,14 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sunvc/NoLets/common"
-	"github.com/sunvc/NoLets/database"
 	"github.com/sunvc/NoLets/push"
-	"github.com/sunvc/apns2"
 )
 
 // BasePush 处理基础推送请求
@@ -21,39 +22,89 @@ func BasePush(c *gin.Context) {
 		return
 	}
 
-	if len(result.Tokens) <= 0 {
-		for _, key := range result.Keys {
-			if len(key) > 5 {
-				if token, err := database.DB.DeviceTokenByKey(key); err == nil {
-					result.Tokens = append(result.Tokens, token)
-				}
+	if result.Err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid params: %v", result.Err))
+		return
+	}
 
-			}
+	// 带有幂等 key 时，窗口期内重复的请求直接返回第一次的结果
+	idempotencyID, err := common.IdempotencyScope(c.GetHeader(common.HeaderIdempotencyKey), result)
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid params: %v", err))
+		return
+	}
+	if idempotencyID != "" {
+		saved, err := push.BeginIdempotent(idempotencyID)
+		if errors.Is(err, push.ErrIdempotencyInProgress) {
+			c.JSON(http.StatusOK, common.Failed(http.StatusConflict, "%v", err))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to check idempotency key: %v", err))
+			return
 		}
+		if saved != nil {
+			c.Header(common.HeaderIdempotentReplayed, "true")
+			c.Data(http.StatusOK, gin.MIMEJSON+"; charset=utf-8", saved.Response)
+			return
+		}
+		defer push.EndIdempotent(idempotencyID)
+	}
+
+	pushType := push.SelectPushType(result)
+
+	// 定时推送，保存到服务端等待到期后再发送
+	if result.SendAt.After(common.DateNow()) {
+		SchedulePush(c, result, pushType, idempotencyID)
+		return
 	}
 
+	push.ResolveTokens(result)
+
 	if len(result.Tokens) <= 0 {
 		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Failed to get device token"))
 		return
 	}
 
-	pushType := func() apns2.EPushType {
-		// 如果 title, subtitle 和 body 都为空，设置静默推送模式
-		if result.PushType == 0 {
-			return apns2.PushTypeBackground
-		}
-		return apns2.PushTypeAlert
-	}()
+	report, err := push.BatchPush(result, pushType)
+
+	// 如果是管理员，加入到重试队列，直到 App 确认收到
+	if id, ok := result.Get(common.ID).(string); common.Admin(c) && ok && len(id) > 0 {
+		UpdateNotPushedData(id, result, pushType, err)
+	}
 
-	if err := push.BatchPush(result, pushType); err != nil {
-		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "push failed: %v", err))
+	if errors.Is(err, push.ErrBatchTooLarge) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "push failed: %v", err))
 		return
 	}
 
-	// 如果是管理员，加入到未推送列表
-	if id, ok := result.Get(common.ID).(string); common.Admin(c) && ok && len(id) > 0 {
-		UpdateNotPushedData(id, result, pushType)
+	// 派发队列已满且没有任何推送成功，让调用方稍后重试
+	// 部分推送成功时按部分成功返回，结果中列出未发送的 token，避免重试时重复推送
+	if errors.Is(err, push.ErrDispatchBusy) && report.Success == 0 {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusOK, common.BaseRes(http.StatusServiceUnavailable, fmt.Sprintf("push failed: %v", err), report))
+		return
 	}
 
-	c.JSON(http.StatusOK, common.Success())
+	switch {
+	case report.Failed == 0:
+		idempotentJSON(c, idempotencyID, common.Success(report))
+	case report.Success > 0:
+		// 部分 token 推送成功，结果中列出每个 token 的状态
+		idempotentJSON(c, idempotencyID, common.BaseRes(http.StatusMultiStatus, "partial success", report))
+	default:
+		// 全部失败时不保存结果，调用方可以使用相同的幂等 key 重试
+		c.JSON(http.StatusOK, common.BaseRes(http.StatusInternalServerError,
+			fmt.Sprintf("push failed: %d of %d deliveries failed", report.Failed, report.Total), report))
+	}
+}
+
+// idempotentJSON 返回推送结果，带有幂等 key 时同时保存，窗口期内重复的请求直接返回该结果
+func idempotentJSON(c *gin.Context, idempotencyID string, res common.BaseResp) {
+	if idempotencyID != "" {
+		if err := push.SaveIdempotent(idempotencyID, res); err != nil {
+			log.Println(fmt.Sprintf("failed to save idempotent result: %v", err))
+		}
+	}
+	c.JSON(http.StatusOK, res)
 }
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/push"
+)
+
+// cronRequest 创建和修改周期任务的请求体
+type cronRequest struct {
+	Spec     string                 `json:"spec"`
+	TimeZone string                 `json:"timezone"`
+	Paused   bool                   `json:"paused"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+// GetCronJobs 列出周期任务
+// 管理员可以查看全部，其他调用方需要通过 key 参数指定设备 key，只返回提供的 key
+func GetCronJobs(c *gin.Context) {
+	jobs, err := push.CronJobs()
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load cron jobs: %v", err))
+		return
+	}
+
+	if !common.Admin(c) {
+		keys := cronRequestKeys(c)
+		if len(keys) <= 0 {
+			c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device key is empty"))
+			return
+		}
+
+		filtered := make([]*common.CronJob, 0, len(jobs))
+		for _, job := range jobs {
+			if cronForKeys(job, keys) {
+				filtered = append(filtered, redactCronJob(job, keys))
+			}
+		}
+		jobs = filtered
+	}
+
+	c.JSON(http.StatusOK, common.Success(jobs))
+}
+
+// GetCronJob 查看单个周期任务
+func GetCronJob(c *gin.Context) {
+	job, ok := loadCronJob(c)
+	if !ok {
+		return
+	}
+	if !common.Admin(c) {
+		job = redactCronJob(job, cronRequestKeys(c))
+	}
+	c.JSON(http.StatusOK, common.Success(job))
+}
+
+// CreateCronJob 新建周期任务
+func CreateCronJob(c *gin.Context) {
+	job := &common.CronJob{
+		ID:         shortuuid.New(),
+		CreateDate: common.DateNow(),
+	}
+	saveCronJob(c, job)
+}
+
+// UpdateCronJob 修改周期任务，请求体与新建相同，会整体替换原有设置
+func UpdateCronJob(c *gin.Context) {
+	job, ok := loadCronJob(c)
+	if !ok || !canModifyCronJob(c, job) {
+		return
+	}
+	saveCronJob(c, job)
+}
+
+// DeleteCronJob 删除周期任务
+func DeleteCronJob(c *gin.Context) {
+	job, ok := loadCronJob(c)
+	if !ok || !canModifyCronJob(c, job) {
+		return
+	}
+
+	if err := push.DeleteCronJob(job.ID); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to delete cron job: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success())
+}
+
+// loadCronJob 读取路径中的任务，非管理员需要通过 key 参数提供任务目标中的设备 key
+func loadCronJob(c *gin.Context) (*common.CronJob, bool) {
+	job, err := push.CronJobByID(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "cron job not found"))
+		} else {
+			c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load cron job: %v", err))
+		}
+		return nil, false
+	}
+
+	if !common.Admin(c) && !cronForKeys(job, cronRequestKeys(c)) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "cron job not found"))
+		return nil, false
+	}
+	return job, true
+}
+
+// canModifyCronJob 非管理员不能修改管理员创建的任务，并且需要提供任务的全部设备 key
+// 任务可能发往多个设备，只持有其中一个 key 不能修改或删除发给其他设备的推送
+func canModifyCronJob(c *gin.Context, job *common.CronJob) bool {
+	if common.Admin(c) {
+		return true
+	}
+	if job.Admin {
+		c.JSON(http.StatusOK, common.Failed(http.StatusForbidden, "cron job was created by an admin"))
+		return false
+	}
+	keys := cronRequestKeys(c)
+	for _, key := range job.Keys {
+		if !common.Contains(keys, key) {
+			c.JSON(http.StatusOK, common.Failed(http.StatusForbidden, "all device keys of the cron job are required"))
+			return false
+		}
+	}
+	return true
+}
+
+// saveCronJob 校验请求体并保存任务
+func saveCronJob(c *gin.Context, job *common.CronJob) {
+	// 限流中间件已经读取过请求体，需要使用缓存的内容
+	var req cronRequest
+	if err := c.ShouldBindBodyWithJSON(&req); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "invalid request body: %v", err))
+		return
+	}
+
+	if err := applyCronRequest(c, job, &req); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	if err := push.SaveCronJob(job); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to save cron job: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success(job))
+}
+
+// applyCronRequest 校验 cron 表达式、时区和推送参数，并写入任务
+func applyCronRequest(c *gin.Context, job *common.CronJob, req *cronRequest) error {
+	admin := common.Admin(c)
+
+	if req.TimeZone == "" {
+		req.TimeZone = common.LocalLocation().String()
+	}
+	nextRun, err := push.CronNext(req.Spec, req.TimeZone, common.DateNow())
+	if err != nil {
+		return err
+	}
+
+	// 与即时推送一样记录回调地址
+	params := common.WithoutSchedule(req.Params)
+	host := common.GetClientHost(c)
+	if admin {
+		params[common.Host] = host
+	}
+	params[common.Callback] = host
+
+	result := common.NewParamsResultFromMap(params)
+	if result == nil {
+		return errors.New("invalid push params")
+	}
+	if result.Err != nil {
+		return result.Err
+	}
+	if len(result.Keys) <= 0 && len(result.Tokens) <= 0 {
+		return errors.New("Failed to get device token")
+	}
+
+	// 非管理员只能给已注册的设备 key 创建任务，之后也通过这些 key 管理任务
+	if !admin {
+		if common.PMGet(result.Params, common.DeviceToken) != "" {
+			return errors.New("device token is only allowed for admins, use device key")
+		}
+		if len(result.Keys) <= 0 {
+			return errors.New("device key is required")
+		}
+		for _, key := range result.Keys {
+			if !database.DB.KeyExists(key) {
+				return fmt.Errorf("device key [%s] not found", key)
+			}
+		}
+	}
+
+	job.Spec = req.Spec
+	job.TimeZone = req.TimeZone
+	job.Paused = req.Paused
+	job.Admin = admin
+	job.Keys = result.Keys
+	job.Params = params
+	job.NextRun = nextRun
+	job.LastError = ""
+	return nil
+}
+
+// cronRequestKeys 返回 key 参数中的设备 key，可以重复提供多个
+func cronRequestKeys(c *gin.Context) []string {
+	var keys []string
+	for _, key := range c.QueryArray("key") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// cronForKeys 判断周期任务是否发往其中一个设备 key
+func cronForKeys(job *common.CronJob, keys []string) bool {
+	for _, key := range keys {
+		if common.Contains(job.Keys, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactCronJob 返回只包含调用方提供的设备 key 的任务副本，不泄露其他接收者的 key 和 token
+func redactCronJob(job *common.CronJob, keys []string) *common.CronJob {
+	redacted := *job
+	redacted.Keys = []string{}
+	for _, key := range job.Keys {
+		if common.Contains(keys, key) {
+			redacted.Keys = append(redacted.Keys, key)
+		}
+	}
+	redacted.Params = common.WithoutTargets(job.Params)
+	return &redacted
+}
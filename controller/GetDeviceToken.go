@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -13,11 +12,22 @@ import (
 // 通过deviceKey查询对应的推送token
 func GetDeviceToken(c *gin.Context) {
 	deviceKey := c.Param("deviceKey")
-	fmt.Println(deviceKey)
-	token, err := database.DB.DeviceTokenByKey(deviceKey)
+	tokens, err := database.DB.DeviceTokensByKey(deviceKey)
 	if err != nil {
 		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to get device token: %v", err))
 		return
 	}
-	c.JSON(http.StatusOK, common.Success(token))
+	if len(tokens) == 0 {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to get device token: no token registered"))
+		return
+	}
+
+	// 兼容单 token 的返回格式，返回最近一次注册的 token
+	latest := tokens[0]
+	for _, token := range tokens[1:] {
+		if token.UpdatedAt.After(latest.UpdatedAt) {
+			latest = token
+		}
+	}
+	c.JSON(http.StatusOK, common.Success(latest.Token))
 }
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+)
+
+// imageCacheControl 图片内容按名称缓存，替换或重命名后通过 ETag 重新验证
+const imageCacheControl = "public, max-age=86400"
+
+// imageInfo 图片列表中的单个图片
+type imageInfo struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	Type    string    `json:"type"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// ServeImage 返回上传的图片，支持 ETag / If-Modified-Since 缓存验证
+func ServeImage(c *gin.Context) {
+	name := c.Param("name")
+	contentType, ok := common.ImageContentType(name)
+	if !ok || !common.ValidImageName(name) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(common.ImageDir(), name))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.Header(common.HeaderContentType, contentType)
+	c.Header("Cache-Control", imageCacheControl)
+	c.Header("ETag", imageETag(stat))
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, name, stat.ModTime(), file)
+}
+
+// GetImages 列出上传的图片
+func GetImages(c *gin.Context) {
+	entries, err := os.ReadDir(common.ImageDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to list images: %v", err))
+		return
+	}
+
+	images := make([]imageInfo, 0, len(entries))
+	for _, entry := range entries {
+		contentType, ok := common.ImageContentType(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		images = append(images, imageInfo{
+			Name:    entry.Name(),
+			URL:     imageURL(entry.Name()),
+			Size:    info.Size(),
+			Type:    contentType,
+			ModTime: info.ModTime().UTC(),
+		})
+	}
+	slices.SortFunc(images, func(a, b imageInfo) int { return strings.Compare(a.Name, b.Name) })
+
+	c.JSON(http.StatusOK, common.Success(images))
+}
+
+// DeleteImage 删除上传的图片
+func DeleteImage(c *gin.Context) {
+	path, ok := imagePath(c)
+	if !ok {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to delete image: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success())
+}
+
+// RenameImage 重命名上传的图片，扩展名保持与图片类型一致
+func RenameImage(c *gin.Context) {
+	path, ok := imagePath(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "invalid request body: %v", err))
+		return
+	}
+	if !common.ValidImageName(req.Name) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "invalid image name"))
+		return
+	}
+
+	name := common.ImageFileName(req.Name, filepath.Ext(path))
+	target := filepath.Join(common.ImageDir(), name)
+	if target != path {
+		if _, err := os.Stat(target); err == nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusConflict, "image %s already exists", name))
+			return
+		}
+		if err := os.Rename(path, target); err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to rename image: %v", err))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, common.Success(gin.H{"name": name, "url": imageURL(name)}))
+}
+
+// imagePath 返回路径中指定的已存在图片
+func imagePath(c *gin.Context) (string, bool) {
+	name := c.Param("name")
+	if _, ok := common.ImageContentType(name); !ok || !common.ValidImageName(name) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "image not found"))
+		return "", false
+	}
+
+	path := filepath.Join(common.ImageDir(), name)
+	if stat, err := os.Stat(path); err != nil || !stat.Mode().IsRegular() {
+		c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "image not found"))
+		return "", false
+	}
+	return path, true
+}
+
+// imageURL 图片的访问路径
+func imageURL(name string) string {
+	return "/" + common.ImageDirName + "/" + name
+}
+
+// imageETag 按修改时间和大小生成弱 ETag，替换图片后缓存会失效
+func imageETag(stat os.FileInfo) string {
+	return `W/"` + strconv.FormatInt(stat.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(stat.Size(), 36) + `"`
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sunvc/NoLets/common"
 	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/push"
 )
 
 func Info(c *gin.Context) {
@@ -23,6 +24,17 @@ func Info(c *gin.Context) {
 	if ok && admin.(bool) {
 		devices, _ := database.DB.CountAll()
 		results["devices"] = devices
+		results["pruned"] = push.PrunedTokenCount()
+		results["queued"], results["workers"] = push.DispatchStats()
+		var apps []string
+		for _, app := range common.AppleApps() {
+			apps = append(apps, app.ID())
+		}
+		results["apps"] = apps
+		results["platforms"] = push.Providers()
+		if expiry := push.CertificateExpiry(); len(expiry) > 0 {
+			results["certExpiry"] = expiry
+		}
 		results["arch"] = runtime.GOOS + "/" + runtime.GOARCH
 		results["cpu"] = runtime.NumCPU()
 	}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/push"
+)
+
+// liveActivityRequest 注册实时活动 token 的请求体
+type liveActivityRequest struct {
+	Key            string `json:"key"`
+	Token          string `json:"token"`
+	Type           string `json:"type"` // start: push-to-start token；update: 已启动活动的 token
+	ActivityID     string `json:"activityId"`
+	AttributesType string `json:"attributesType"`
+	Env            string `json:"env"`
+	App            string `json:"app"`
+}
+
+// RegisterLiveActivity 注册实时活动 token，key 需要已经通过 /register 注册
+func RegisterLiveActivity(c *gin.Context) {
+	var req liveActivityRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "failed to get live activity token: %v", err))
+		return
+	}
+
+	if req.Key == "" || !database.DB.KeyExists(req.Key) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device key is not exist"))
+		return
+	}
+
+	if len(req.Token) < 60 || len(req.Token) > 512 {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid live activity token"))
+		return
+	}
+
+	req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+	if req.Type != common.LiveActivityStart && req.Type != common.LiveActivityUpdate {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid type, expected start or update"))
+		return
+	}
+
+	env, ok := common.NormalizeEnv(req.Env)
+	if !ok {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid env, expected development or production"))
+		return
+	}
+
+	app, ok := common.AppleApp(req.App)
+	if !ok {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid app: %s", req.App))
+		return
+	}
+	if req.App != "" {
+		req.App = app.ID()
+	}
+
+	err := push.SaveLiveActivityToken(req.Key, common.LiveActivityToken{
+		TokenInfo: common.TokenInfo{
+			Token: req.Token,
+			Env:   env,
+			App:   req.App,
+		},
+		Type:           req.Type,
+		ActivityID:     req.ActivityID,
+		AttributesType: req.AttributesType,
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "live activity token registration failed: %v", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.Success())
+}
+
+// GetLiveActivityTokens 列出 key 下注册的实时活动 token，仅管理员可用
+func GetLiveActivityTokens(c *gin.Context) {
+	tokens, err := push.LiveActivityTokens(c.Param("deviceKey"))
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load live activity tokens: %v", err))
+		return
+	}
+
+	for i := range tokens {
+		tokens[i].Token = push.MaskToken(tokens[i].Token)
+	}
+	c.JSON(http.StatusOK, common.Success(tokens))
+}
+
+// UnregisterLiveActivity 移除实时活动 token，不带 token 参数时移除该 key 下的全部
+func UnregisterLiveActivity(c *gin.Context) {
+	if err := push.RemoveLiveActivityToken(c.Param("deviceKey"), c.Query("token")); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "live activity token remove failed: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success())
+}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/push"
+)
+
+// GetMessage 返回服务端保存的完整长消息
+// 需要提供预览推送中的 messagetoken（X-Message-Token 请求头或 token 参数），管理员可以直接查看
+func GetMessage(c *gin.Context) {
+	msg, err := push.MessageByID(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, push.ErrMessageNotFound) {
+			c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "message not found"))
+		} else {
+			c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load message: %v", err))
+		}
+		return
+	}
+
+	token := c.GetHeader("X-Message-Token")
+	if token == "" {
+		token = c.Query("token")
+	}
+	if !common.Admin(c) && subtle.ConstantTimeCompare([]byte(token), []byte(msg.Token)) != 1 {
+		// 与不存在的消息返回相同的结果，避免探测消息 ID
+		c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "message not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.Success(gin.H{
+		"id":         msg.ID,
+		"title":      msg.Title,
+		"subtitle":   msg.Subtitle,
+		"body":       msg.Body,
+		"createDate": msg.CreateDate,
+		"expiresAt":  msg.ExpiresAt,
+	}))
+}
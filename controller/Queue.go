@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/push"
+)
+
+// GetQueue 查看重试队列中等待推送的消息
+func GetQueue(c *gin.Context) {
+	listQueue(c, push.QueueBucket)
+}
+
+// GetDeadQueue 查看超过最大推送次数的死信消息
+func GetDeadQueue(c *gin.Context) {
+	listQueue(c, push.DeadBucket)
+}
+
+// DeleteQueueItem 删除重试队列中的消息
+func DeleteQueueItem(c *gin.Context) {
+	deleteQueueItem(c, push.QueueBucket)
+}
+
+// DeleteDeadItem 删除死信消息
+func DeleteDeadItem(c *gin.Context) {
+	deleteQueueItem(c, push.DeadBucket)
+}
+
+// RetryDeadItem 将死信消息放回重试队列
+func RetryDeadItem(c *gin.Context) {
+	if err := push.RetryDead(c.Param("id")); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "failed to retry message: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success())
+}
+
+func listQueue(c *gin.Context, bucket string) {
+	items, err := push.QueueItems(bucket)
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load queue: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success(items))
+}
+
+func deleteQueueItem(c *gin.Context, bucket string) {
+	if err := push.DeleteQueueItem(bucket, c.Param("id")); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "failed to delete message: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success())
+}
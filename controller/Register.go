@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sunvc/NoLets/common"
 	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/push"
 )
 
 // Register 处理设备注册请求
@@ -27,7 +28,7 @@ func Register(c *gin.Context) {
 			admin, ok := c.Get("admin")
 
 			if ok && admin.(bool) {
-				_, err := database.DB.SaveDeviceTokenByKey(deviceKey, "")
+				_, err := database.DB.SaveDeviceTokenByKey(deviceKey, common.TokenInfo{})
 				if err != nil {
 					c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device key is not exist"))
 					return
@@ -49,16 +50,111 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	if len(device.Token) != 64 && len(device.Token) != 36 {
+	platform, ok := common.NormalizePlatform(device.Platform)
+	if !ok {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid platform, expected ios or android"))
+		return
+	}
+	device.Platform = platform
+	if platform == common.PlatformWeb {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "browser subscriptions are registered with /webpush"))
+		return
+	}
+
+	if !validDeviceToken(platform, device.Token) {
 		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid deviceToken"))
 		return
 	}
 
-	device.Key, err = database.DB.SaveDeviceTokenByKey(device.Key, device.Token)
+	env, ok := common.NormalizeEnv(device.Env)
+	if !ok {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid env, expected development or production"))
+		return
+	}
+	device.Env = env
+
+	// 多 App 部署时设备需要指定注册到哪个 App，为空时使用默认 App
+	// Android 设备通过 FCM 推送，不区分 App 和 APNs 环境
+	if platform == common.PlatformAndroid {
+		device.App, device.Env = "", ""
+	} else {
+		app, ok := common.AppleApp(device.App)
+		if !ok {
+			c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid app: %s", device.App))
+			return
+		}
+		if device.App != "" {
+			device.App = app.ID()
+		}
+	}
+
+	// 设备公钥用于服务端加密推送内容，统一保存为原始公钥的 base64
+	if device.PublicKey != "" {
+		if device.PublicKey, err = common.NormalizeDevicePublicKey(device.PublicKey); err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid publicKey: %v", err))
+			return
+		}
+	}
+
+	// 同一个 key 可以注册多台设备，新的 token 会追加到该 key 下
+	device.Key, err = database.DB.SaveDeviceTokenByKey(device.Key, common.TokenInfo{
+		Token:     device.Token,
+		Name:      device.Name,
+		Env:       device.Env,
+		App:       device.App,
+		Platform:  device.Platform,
+		PublicKey: device.PublicKey,
+	})
 
 	if err != nil {
 		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "device registration failed: %v", err))
+		return
 	}
 
 	c.JSON(http.StatusOK, common.Success(device))
 }
+
+// validDeviceToken APNs token 为 64 位十六进制 (模拟器为 36 位 UUID)
+// FCM registration token 长度不固定，只检查长度和字符
+func validDeviceToken(platform, token string) bool {
+	if platform != common.PlatformAndroid {
+		return len(token) == 64 || len(token) == 36
+	}
+	if len(token) < 32 || len(token) > 255 {
+		return false
+	}
+	for _, r := range token {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// Unregister 删除设备key及其对应的token
+// 带 token 参数时只移除该 key 下的这一个 token
+// 仅允许管理员或通过签名校验的App调用
+func Unregister(c *gin.Context) {
+	deviceKey := c.Param("deviceKey")
+	if deviceKey == "" {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device key is empty"))
+		return
+	}
+
+	if token := c.Query("token"); token != "" {
+		if err := database.DB.RemoveDeviceTokenByKey(deviceKey, token); err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device token remove failed: %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, common.Success())
+		return
+	}
+
+	if err := database.DB.DeleteDeviceByKey(deviceKey); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device unregister failed: %v", err))
+		return
+	}
+	_ = push.RemoveLiveActivityToken(deviceKey, "")
+
+	c.JSON(http.StatusOK, common.Success())
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/push"
+	"github.com/sunvc/apns2"
+)
+
+// scheduledSummary 非管理员查询定时推送时返回的内容
+// 定时推送可能发往多个设备，不返回推送参数，避免泄露其他接收者的 key 和 token
+type scheduledSummary struct {
+	ID         string    `json:"id"`
+	MessageID  string    `json:"messageId"`
+	SendAt     time.Time `json:"sendAt"`
+	CreateDate time.Time `json:"createDate"`
+}
+
+// SchedulePush 保存定时推送，返回定时推送的 id 用于查询和取消
+// 定时推送使用单独生成的 id，消息 id 相同时不会互相覆盖
+// idempotencyID 不为空时保存返回结果，重复的请求不会再次创建定时推送
+func SchedulePush(c *gin.Context, result *common.ParamsResult, pushType apns2.EPushType, idempotencyID string) {
+	if len(result.Keys) <= 0 && len(result.Tokens) <= 0 {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Failed to get device token"))
+		return
+	}
+
+	id, _ := result.Get(common.ID).(string)
+	if id == "" {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid message id"))
+		return
+	}
+
+	item := &common.ScheduledData{
+		ID:         shortuuid.New(),
+		SendAt:     result.SendAt,
+		CreateDate: common.DateNow(),
+		Admin:      common.Admin(c),
+		Params:     result,
+		PushType:   pushType,
+	}
+
+	if err := push.Schedule(item); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to schedule push: %v", err))
+		return
+	}
+
+	idempotentJSON(c, idempotencyID, common.Success(gin.H{
+		"id":        item.ID,
+		"messageId": id,
+		"sendAt":    item.SendAt,
+	}))
+}
+
+// GetScheduled 列出等待发送的定时推送
+// 管理员可以查看全部，其他调用方需要通过 key 参数指定设备 key，只返回 id 和发送时间
+func GetScheduled(c *gin.Context) {
+	items, err := push.ScheduledItems()
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load scheduled pushes: %v", err))
+		return
+	}
+
+	if common.Admin(c) {
+		c.JSON(http.StatusOK, common.Success(items))
+		return
+	}
+
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device key is empty"))
+		return
+	}
+
+	summaries := make([]scheduledSummary, 0, len(items))
+	for _, item := range items {
+		if !scheduledForKey(item, key) {
+			continue
+		}
+		messageID, _ := item.Params.Get(common.ID).(string)
+		summaries = append(summaries, scheduledSummary{
+			ID:         item.ID,
+			MessageID:  messageID,
+			SendAt:     item.SendAt,
+			CreateDate: item.CreateDate,
+		})
+	}
+	c.JSON(http.StatusOK, common.Success(summaries))
+}
+
+// CancelScheduled 取消尚未发送的定时推送
+// 非管理员需要通过 key 参数提供消息目标中的设备 key，发往多个设备时只取消发给该 key 的推送
+func CancelScheduled(c *gin.Context) {
+	id := c.Param("id")
+
+	var err error
+	if common.Admin(c) {
+		err = push.CancelSchedule(id)
+	} else {
+		err = push.CancelScheduleForKey(id, c.Query("key"))
+	}
+	if errors.Is(err, database.ErrRecordNotFound) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "scheduled push not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to cancel scheduled push: %v", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.Success())
+}
+
+// scheduledForKey 判断定时推送是否发往指定的设备 key
+func scheduledForKey(item *common.ScheduledData, key string) bool {
+	return key != "" && item.Params != nil && common.Contains(item.Params.Keys, key)
+}
@@ -1,19 +1,22 @@
 package controller
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/push"
 	"github.com/sunvc/NoLets/serverInfo"
 )
 
-// GetServerInfo 返回服务器监控信息
+// GetServerInfo 返回服务器监控信息，apns 为每个 APNs 连接的统计
 func GetServerInfo(c *gin.Context) {
+	results := gin.H{}
 
-	data, err := serverInfo.GetServerInfo()
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{})
-		return
+	if data, err := serverInfo.GetServerInfo(); err == nil {
+		_ = json.Unmarshal(data, &results)
 	}
-	c.Data(http.StatusOK, "application/json", data)
+	results["apns"] = push.PoolStatsAll()
+
+	c.JSON(http.StatusOK, results)
 }
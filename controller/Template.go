@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/push"
+)
+
+// GetTemplates 列出所有推送模版
+func GetTemplates(c *gin.Context) {
+	templates, err := push.Templates()
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load templates: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success(templates))
+}
+
+// GetTemplate 查看单个推送模版
+func GetTemplate(c *gin.Context) {
+	tmpl, ok := loadTemplate(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, common.Success(tmpl))
+}
+
+// CreateTemplate 新建推送模版，名称已存在时失败
+func CreateTemplate(c *gin.Context) {
+	tmpl := &common.Template{}
+	if err := c.ShouldBindJSON(tmpl); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "invalid request body: %v", err))
+		return
+	}
+
+	if _, err := push.TemplateByName(tmpl.Name); err == nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusConflict, "template %s already exists", tmpl.Name))
+		return
+	} else if !errors.Is(err, push.ErrTemplateNotFound) {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load template: %v", err))
+		return
+	}
+
+	tmpl.CreateDate = common.DateNow()
+	saveTemplate(c, tmpl)
+}
+
+// UpdateTemplate 修改推送模版，请求体会整体替换原有内容
+func UpdateTemplate(c *gin.Context) {
+	current, ok := loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	tmpl := &common.Template{}
+	if err := c.ShouldBindJSON(tmpl); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "invalid request body: %v", err))
+		return
+	}
+	tmpl.Name = current.Name
+	tmpl.CreateDate = current.CreateDate
+	saveTemplate(c, tmpl)
+}
+
+// DeleteTemplate 删除推送模版
+func DeleteTemplate(c *gin.Context) {
+	tmpl, ok := loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	if err := push.DeleteTemplate(tmpl.Name); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to delete template: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success())
+}
+
+// loadTemplate 读取路径中的模版
+func loadTemplate(c *gin.Context) (*common.Template, bool) {
+	tmpl, err := push.TemplateByName(c.Param("name"))
+	if err != nil {
+		if errors.Is(err, push.ErrTemplateNotFound) {
+			c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "template not found"))
+		} else {
+			c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to load template: %v", err))
+		}
+		return nil, false
+	}
+	return tmpl, true
+}
+
+// saveTemplate 校验模版语法并保存
+func saveTemplate(c *gin.Context, tmpl *common.Template) {
+	if err := tmpl.Check(); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	tmpl.UpdateDate = common.DateNow()
+	if err := push.SaveTemplate(tmpl); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "failed to save template: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success(tmpl))
+}
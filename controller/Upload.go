@@ -1,12 +1,11 @@
 package controller
 
 import (
-	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sunvc/NoLets/common"
@@ -37,19 +36,17 @@ func Upload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "filename is required"})
 		return
 	}
+	if !common.ValidImageName(fileName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename"})
+		return
+	}
 
 	// 创建上传目录
-	uploadDir := "./images"
+	uploadDir := common.ImageDir()
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload directory"})
 		return
 	}
-	// 验证文件是否在uploads目录下
-	if isTrue, _ := common.IsFileInDirectory(fileName, uploadDir); isTrue {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found in uploads directory"})
-		return
-
-	}
 
 	// 获取上传的文件
 	file, err := c.FormFile("file")
@@ -57,27 +54,29 @@ func Upload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file upload failed: " + err.Error()})
 		return
 	}
-
-	// 验证文件类型
-	imageType := file.Header.Get(common.HeaderContentType)
-	if imageType != common.MIMEImagePng && imageType != common.MIMEImageJpeg {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "only image files are allowed"})
+	if file.Size > common.MaxImageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
 		return
 	}
 
-	// 生成安全的文件名
-	ext := filepath.Ext(fileName)
-	if ext == "" {
-		ext = filepath.Ext(file.Filename)
+	// 根据文件内容验证类型，不信任 multipart 中的 Content-Type
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file upload failed: " + err.Error()})
+		return
 	}
-	if ext == "" {
-		ext = ".png" // 默认扩展名
+	defer func() { _ = src.Close() }()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(src, head)
+	imageType, ext, ok := common.DetectImageType(head[:n])
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only image files are allowed"})
+		return
 	}
 
-	// 使用时间戳和随机字符串生成唯一文件名
-	safeFileName := fmt.Sprintf("%s%s",
-		strings.TrimSuffix(fileName, ext),
-		strings.ToLower(ext))
+	// 扩展名与实际类型保持一致
+	safeFileName := common.ImageFileName(fileName, ext)
 
 	// 完整的文件路径
 	filePath := filepath.Join(uploadDir, safeFileName)
@@ -92,8 +91,8 @@ func Upload(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "file uploaded successfully",
 		"filename": safeFileName,
-		"path":     filePath,
+		"path":     imageURL(safeFileName),
 		"size":     file.Size,
-		"type":     file.Header.Get("Content-Type"),
+		"type":     imageType,
 	})
 }
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/push"
+)
+
+// MaxSubscriptionSize 订阅请求体的最大长度
+const MaxSubscriptionSize = 4096
+
+// webPushRequest 注册浏览器订阅的请求体
+type webPushRequest struct {
+	Key          string                      `json:"key"` // 为空时创建新的 key
+	Name         string                      `json:"name"`
+	Subscription *common.WebPushSubscription `json:"subscription"`
+}
+
+// WebPushKey 返回浏览器订阅时使用的 VAPID 公钥
+func WebPushKey(c *gin.Context) {
+	key := push.VAPIDPublicKey()
+	if key == "" {
+		c.JSON(http.StatusOK, common.Failed(http.StatusServiceUnavailable, "web push is not enabled"))
+		return
+	}
+	c.JSON(http.StatusOK, common.Success(gin.H{"publicKey": key}))
+}
+
+// RegisterWebPush 把浏览器的 PushSubscription 注册到设备 key 下
+// 之后发给该 key 的推送也会发送到浏览器
+func RegisterWebPush(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxSubscriptionSize)
+
+	var req webPushRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "failed to get subscription: %v", err))
+		return
+	}
+	if req.Subscription == nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "subscription is empty"))
+		return
+	}
+	if err := req.Subscription.Check(common.LocalConfig.WebPush.AllowHTTP); err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "Invalid subscription: %v", err))
+		return
+	}
+
+	token := req.Subscription.ID()
+	key, err := database.DB.SaveDeviceTokenByKey(req.Key, common.TokenInfo{
+		Token:        token,
+		Name:         req.Name,
+		Platform:     common.PlatformWeb,
+		Subscription: req.Subscription,
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "subscription registration failed: %v", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.Success(gin.H{"key": key, "token": token}))
+}
+
+// ServiceWorker 返回浏览器订阅使用的 service worker，作用域为整个站点
+func ServiceWorker(c *gin.Context) {
+	data, err := common.StaticFS.ReadFile("static/sw.js")
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Header("Service-Worker-Allowed", "/")
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "text/javascript; charset=utf-8", data)
+}
+
+// UnregisterWebPush 移除 key 下的浏览器订阅，token 为注册时返回的订阅 ID
+func UnregisterWebPush(c *gin.Context) {
+	deviceKey := c.Param("deviceKey")
+	token := c.Query("token")
+
+	tokens, err := database.DB.DeviceTokensByKey(deviceKey)
+	if err != nil {
+		c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "device key is not exist"))
+		return
+	}
+	for _, t := range tokens {
+		if t.Token != token || t.Platform != common.PlatformWeb {
+			continue
+		}
+		if err = database.DB.RemoveDeviceTokenByKey(deviceKey, token); err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "subscription remove failed: %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, common.Success())
+		return
+	}
+	c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "subscription not found"))
+}
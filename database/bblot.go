@@ -1,6 +1,8 @@
 package database
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -42,26 +44,26 @@ func (d *BboltDB) Close() error {
 	return BBDB.Close()
 }
 
-func (d *BboltDB) DeviceTokenByKey(key string) (string, error) {
-	var token string
+func (d *BboltDB) DeviceTokensByKey(key string) ([]common.TokenInfo, error) {
+	var tokens []common.TokenInfo
 	err := BBDB.View(func(tx *bbolt.Tx) error {
 		if bs := tx.Bucket([]byte(common.LocalConfig.System.Name)).Get([]byte(key)); bs == nil {
 			return fmt.Errorf("failed to get [%s] device token from database", key)
 		} else {
-			token = string(bs)
+			tokens = decodeBboltTokens(bs)
 			return nil
 		}
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return token, nil
+	return tokens, nil
 }
 
-// SaveDeviceToken create or update device token of specified key
+// SaveDeviceTokenByKey create the key or add/update one of its device tokens
 
-func (d *BboltDB) SaveDeviceTokenByKey(key, deviceToken string) (string, error) {
+func (d *BboltDB) SaveDeviceTokenByKey(key string, token common.TokenInfo) (string, error) {
 	err := BBDB.Update(func(tx *bbolt.Tx) error {
 
 		bucket := tx.Bucket([]byte(common.LocalConfig.System.Name))
@@ -71,8 +73,24 @@ func (d *BboltDB) SaveDeviceTokenByKey(key, deviceToken string) (string, error)
 			// Generate a new UUID as the deviceKey when a new device register
 			key = shortuuid.New()
 		}
-		// update the deviceToken
-		return bucket.Put([]byte(key), []byte(deviceToken))
+
+		tokens := decodeBboltTokens(bucket.Get([]byte(key)))
+		if token.Token != "" {
+			var removed []string
+			tokens, removed = mergeDeviceToken(tokens, token)
+
+			index := tx.Bucket(tokenIndexBucket())
+			for _, t := range removed {
+				if err := index.Delete(tokenIndexKey(t, key)); err != nil {
+					return err
+				}
+			}
+			if err := index.Put(tokenIndexKey(token.Token, key), []byte{}); err != nil {
+				return err
+			}
+		}
+		// update the deviceToken list
+		return putBboltTokens(bucket, []byte(key), tokens)
 	})
 
 	if err != nil {
@@ -82,6 +100,94 @@ func (d *BboltDB) SaveDeviceTokenByKey(key, deviceToken string) (string, error)
 	return key, nil
 }
 
+// RemoveDeviceTokenByKey 从指定 key 中移除一个 token
+func (d *BboltDB) RemoveDeviceTokenByKey(key, token string) error {
+	return BBDB.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(common.LocalConfig.System.Name))
+		bs := bucket.Get([]byte(key))
+		if bs == nil {
+			return fmt.Errorf("device key [%s] not found", key)
+		}
+
+		tokens := decodeBboltTokens(bs)
+		result := filterTokens(tokens, token)
+		if len(result) == len(tokens) {
+			return fmt.Errorf("device token not found in key [%s]", key)
+		}
+		if err := tx.Bucket(tokenIndexBucket()).Delete(tokenIndexKey(token, key)); err != nil {
+			return err
+		}
+		return putBboltTokens(bucket, []byte(key), result)
+	})
+}
+
+// tokenIndexBucket token 到 key 的反向索引，删除失效 token 时不需要遍历所有 key
+// 索引的键为 token + "\x00" + key，值为空
+func tokenIndexBucket() []byte {
+	return []byte(common.LocalConfig.System.Name + "_token_index")
+}
+
+func tokenIndexKey(token, key string) []byte {
+	return []byte(token + "\x00" + key)
+}
+
+// rebuildBboltTokenIndex 启动时重建反向索引，包含旧版本写入的 token
+func rebuildBboltTokenIndex(tx *bbolt.Tx) error {
+	if tx.Bucket(tokenIndexBucket()) != nil {
+		if err := tx.DeleteBucket(tokenIndexBucket()); err != nil {
+			return err
+		}
+	}
+	index, err := tx.CreateBucket(tokenIndexBucket())
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(common.LocalConfig.System.Name)).ForEach(func(k, v []byte) error {
+		for _, t := range decodeBboltTokens(v) {
+			if err := index.Put(tokenIndexKey(t.Token, string(k)), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// decodeBboltTokens 解析 bucket 中保存的 token 列表
+// 旧版本直接保存 token 字符串，读取时视为只有一个 token
+func decodeBboltTokens(value []byte) []common.TokenInfo {
+	if len(value) == 0 {
+		return nil
+	}
+	var tokens []common.TokenInfo
+	if value[0] == '[' && json.Unmarshal(value, &tokens) == nil {
+		return tokens
+	}
+	return []common.TokenInfo{{Token: string(value)}}
+}
+
+func putBboltTokens(bucket *bbolt.Bucket, key []byte, tokens []common.TokenInfo) error {
+	if len(tokens) == 0 {
+		return bucket.Put(key, []byte(""))
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// filterTokens 返回去掉指定 token 后的列表
+func filterTokens(tokens []common.TokenInfo, token string) []common.TokenInfo {
+	result := make([]common.TokenInfo, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Token != token {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
 // bboltSetup set up the bbolt database
 func bboltSetup(dataDir string) {
 	dbOnce.Do(func() {
@@ -100,8 +206,10 @@ func bboltSetup(dataDir string) {
 		}
 
 		err = bboltDB.Update(func(tx *bbolt.Tx) error {
-			_, err = tx.CreateBucketIfNotExists([]byte(common.LocalConfig.System.Name))
-			return err
+			if _, err = tx.CreateBucketIfNotExists([]byte(common.LocalConfig.System.Name)); err != nil {
+				return err
+			}
+			return rebuildBboltTokenIndex(tx)
 		})
 		if err != nil {
 			log.Println(fmt.Sprintf("failed to create database bucket: %v", err))
@@ -128,3 +236,124 @@ func (d *BboltDB) KeyExists(key string) bool {
 	// 如果 err 为 nil，说明 key 存在，否则 key 不存在
 	return err == nil
 }
+
+// DeleteDeviceToken 从所有 key 中移除指定的 token，key 本身保留以便 App 重新注册
+func (d *BboltDB) DeleteDeviceToken(token string) (int, error) {
+	var count int
+	err := BBDB.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(common.LocalConfig.System.Name))
+		index := tx.Bucket(tokenIndexBucket())
+
+		// 通过反向索引找到持有该 token 的 key
+		prefix := tokenIndexKey(token, "")
+		var entries [][]byte
+		c := index.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			entries = append(entries, append([]byte{}, k...))
+		}
+
+		for _, entry := range entries {
+			key := entry[len(prefix):]
+			tokens := decodeBboltTokens(bucket.Get(key))
+			if result := filterTokens(tokens, token); len(result) != len(tokens) {
+				if err := putBboltTokens(bucket, key, result); err != nil {
+					return err
+				}
+				count++
+			}
+			if err := index.Delete(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// DeleteDeviceByKey 删除指定的设备 key
+func (d *BboltDB) DeleteDeviceByKey(key string) error {
+	return BBDB.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(common.LocalConfig.System.Name))
+		bs := bucket.Get([]byte(key))
+		if bs == nil {
+			return fmt.Errorf("device key [%s] not found", key)
+		}
+
+		index := tx.Bucket(tokenIndexBucket())
+		for _, t := range decodeBboltTokens(bs) {
+			if err := index.Delete(tokenIndexKey(t.Token, key)); err != nil {
+				return err
+			}
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// recordBucket 通用记录所在的 bucket 名称
+func recordBucket(bucket string) []byte {
+	return []byte(common.LocalConfig.System.Name + "_" + bucket)
+}
+
+func (d *BboltDB) SaveRecord(bucket, id string, data []byte) error {
+	return BBDB.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(recordBucket(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), data)
+	})
+}
+
+func (d *BboltDB) RecordByID(bucket, id string) ([]byte, error) {
+	var data []byte
+	err := BBDB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(recordBucket(bucket))
+		if b == nil {
+			return ErrRecordNotFound
+		}
+		bs := b.Get([]byte(id))
+		if bs == nil {
+			return ErrRecordNotFound
+		}
+		data = append([]byte{}, bs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (d *BboltDB) DeleteRecord(bucket, id string) error {
+	return BBDB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(recordBucket(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (d *BboltDB) Records(bucket string) ([]Record, error) {
+	var records []Record
+	err := BBDB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(recordBucket(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			records = append(records, Record{ID: string(k), Data: append([]byte{}, v...)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
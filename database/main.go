@@ -1,24 +1,122 @@
 package database
 
-import "github.com/sunvc/NoLets/common"
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/sunvc/NoLets/common"
+)
+
+// MaxTokensPerKey 每个设备 key 最多保存的 token 数量，超出时淘汰最久未更新的 token
+const MaxTokensPerKey = 10
 
 var DB Database
 
 // Database defines all the db operation
 type Database interface {
-	CountAll() (int, error)                                 //Get db records count
-	DeviceTokenByKey(key string) (string, error)            //Get specified device's token
-	SaveDeviceTokenByKey(key, token string) (string, error) //Create or update specified device's token
+	CountAll() (int, error)                                                  //Get db records count
+	DeviceTokensByKey(key string) ([]common.TokenInfo, error)                //Get all tokens of specified device key
+	SaveDeviceTokenByKey(key string, token common.TokenInfo) (string, error) //Create the key or add/update one of its tokens
+	RemoveDeviceTokenByKey(key, token string) error                          //Remove one token from specified device key
 	KeyExists(key string) bool
-	Close() error //Close the database
+	DeleteDeviceToken(token string) (int, error)     //Remove an invalid token from every key holding it
+	DeleteDeviceByKey(key string) error              //Delete specified device key
+	SaveRecord(bucket, id string, data []byte) error //Create or update a record in the bucket
+	RecordByID(bucket, id string) ([]byte, error)    //Get a record, ErrRecordNotFound if missing
+	DeleteRecord(bucket, id string) error            //Delete a record from the bucket
+	Records(bucket string) ([]Record, error)         //Get all records of the bucket ordered by id
+	Close() error                                    //Close the database
+}
+
+// Record 按 bucket 分组保存的通用 JSON 记录，供重试队列等功能持久化数据
+type Record struct {
+	ID   string
+	Data []byte
 }
 
+var ErrRecordNotFound = errors.New("record not found")
+
+// ErrMigrateTokens 旧版本 token 迁移失败，此时不能退回 bbolt，否则已注册的设备会全部丢失
+var ErrMigrateTokens = errors.New("failed to migrate device tokens")
+
 func InitDatabase() {
-	if dsn := common.LocalConfig.System.DSN; len(dsn) > 10 {
-		if database, err := NewMySQL(dsn); err == nil {
-			DB = database
-			return
-		}
+	dsn := common.LocalConfig.System.DSN
+
+	var database Database
+	var err error
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		database, err = NewPostgreSQL(dsn)
+	case strings.HasPrefix(dsn, "sqlite://"):
+		database, err = NewSQLite(dsn)
+	case len(dsn) > 10:
+		database, err = NewMySQL(dsn)
+	default:
+		DB = NewBboltdb(common.BaseDir())
+		return
+	}
+
+	if errors.Is(err, ErrMigrateTokens) {
+		log.Fatal(err)
+	}
+	if err == nil {
+		DB = database
+		return
 	}
 	DB = NewBboltdb(common.BaseDir())
 }
+
+// tokenTable 多 token 存储使用的表名
+func tokenTable() string {
+	return common.LocalConfig.System.Name + "_tokens"
+}
+
+// recordTable 通用记录使用的表名
+func recordTable() string {
+	return common.LocalConfig.System.Name + "_records"
+}
+
+// mergeDeviceToken 将 token 合并进已有列表，保留原有的创建时间
+// 返回合并后的列表，以及因超出 MaxTokensPerKey 被淘汰的 token
+func mergeDeviceToken(tokens []common.TokenInfo, token common.TokenInfo) ([]common.TokenInfo, []string) {
+	now := common.DateNow()
+	token.CreatedAt = now
+	token.UpdatedAt = now
+
+	result := make([]common.TokenInfo, 0, len(tokens)+1)
+	for _, t := range tokens {
+		if t.Token == token.Token {
+			if !t.CreatedAt.IsZero() {
+				token.CreatedAt = t.CreatedAt
+			}
+			continue
+		}
+		result = append(result, t)
+	}
+	result = append(result, token)
+
+	var removed []string
+	if over := len(result) - MaxTokensPerKey; over > 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].UpdatedAt.Before(result[j].UpdatedAt)
+		})
+		for _, t := range result[:over] {
+			removed = append(removed, t.Token)
+		}
+		result = result[over:]
+	}
+
+	return result, removed
+}
+
+// decodeDeviceToken 解析存储的 token 元数据，兼容只保存了 token 字符串的旧数据
+func decodeDeviceToken(token string, info []byte) common.TokenInfo {
+	var deviceToken common.TokenInfo
+	if err := json.Unmarshal(info, &deviceToken); err != nil || deviceToken.Token == "" {
+		deviceToken.Token = token
+	}
+	return deviceToken
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
@@ -22,24 +24,80 @@ func CreateDbSchema() string {
 		"    `key` VARCHAR(255) NOT NULL," +
 		"    `token` VARCHAR(255) NOT NULL," +
 		"    PRIMARY KEY (`id`)," +
-		"    UNIQUE KEY `key` (`key`)" +
+		"    UNIQUE KEY `key` (`key`)," +
+		"    KEY `token` (`token`)" +
 		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
 }
 
+// CreateTokenSchema 每个 key 下的多个 token，info 保存 token 元数据的 JSON
+func CreateTokenSchema() string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS  `%s` (", tokenTable()) +
+		"    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"    `key` VARCHAR(255) NOT NULL," +
+		"    `token` VARCHAR(255) NOT NULL," +
+		"    `info` TEXT NOT NULL," +
+		"    PRIMARY KEY (`id`)," +
+		"    UNIQUE KEY `key_token` (`key`,`token`)," +
+		"    KEY `token` (`token`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+}
+
+// CreateRecordSchema 通用记录表，data 保存 JSON
+func CreateRecordSchema() string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS  `%s` (", recordTable()) +
+		"    `bucket` VARCHAR(64) NOT NULL," +
+		"    `id` VARCHAR(255) NOT NULL," +
+		"    `data` MEDIUMTEXT NOT NULL," +
+		"    PRIMARY KEY (`bucket`,`id`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+}
+
+// ensureMySQLTokenIndex 为旧版本创建的表补充 token 索引，MySQL 不支持 CREATE INDEX IF NOT EXISTS
+func ensureMySQLTokenIndex(db *sql.DB, table string) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(1) FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name=? AND index_name='token'", table).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX `token` ON `%s` (`token`)", table))
+	return err
+}
+
+// migrateMySQLTokens 将旧版本保存在主表中的单个 token 复制到 token 表，可以重复执行
+// 主表中的 token 保留，回退到旧版本时仍然可用
+func migrateMySQLTokens(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf("INSERT IGNORE INTO `%s` (`key`,`token`,`info`) SELECT `key`,`token`,JSON_OBJECT('token',`token`) FROM `%s` WHERE `token`<>''",
+		tokenTable(), common.LocalConfig.System.Name))
+	return err
+}
+
 func NewMySQL(dsn string) (Database, error) {
 	db, err := sql.Open("mysql", dsn)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("failed to open database connection (%s)", dsn), err)
 	}
-	dbSchema := CreateDbSchema()
-	_, err = db.Exec(dbSchema)
-	if err != nil {
-		log.Println(fmt.Sprintf("failed to init database schema(%s)", dbSchema), err)
+	for _, dbSchema := range []string{CreateDbSchema(), CreateTokenSchema(), CreateRecordSchema()} {
+		if _, err = db.Exec(dbSchema); err != nil {
+			log.Println(fmt.Sprintf("failed to init database schema(%s)", dbSchema), err)
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	for _, table := range []string{common.LocalConfig.System.Name, tokenTable()} {
+		if err = ensureMySQLTokenIndex(db, table); err != nil {
+			log.Println(fmt.Sprintf("failed to create token index on %s", table), err)
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	if err = migrateMySQLTokens(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%w: %v", ErrMigrateTokens, err)
 	}
 
 	mysqlDB = db
-	return &MySQL{}, err
+	return &MySQL{}, nil
 }
 
 func (d *MySQL) CountAll() (int, error) {
@@ -53,32 +111,87 @@ func (d *MySQL) CountAll() (int, error) {
 	return count, nil
 }
 
-func (d *MySQL) DeviceTokenByKey(key string) (string, error) {
-	var token string
-	rawString := fmt.Sprintf("SELECT `token` FROM `%s` WHERE `key`=?", common.LocalConfig.System.Name)
-	err := mysqlDB.QueryRow(rawString, key).Scan(&token)
+func (d *MySQL) DeviceTokensByKey(key string) ([]common.TokenInfo, error) {
+	if !d.KeyExists(key) {
+		return nil, fmt.Errorf("failed to get [%s] device token from database", key)
+	}
+
+	rawString := fmt.Sprintf("SELECT `token`,`info` FROM `%s` WHERE `key`=? ORDER BY `id`", tokenTable())
+	rows, err := mysqlDB.Query(rawString, key)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var tokens []common.TokenInfo
+	for rows.Next() {
+		var token string
+		var info []byte
+		if err = rows.Scan(&token, &info); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, decodeDeviceToken(token, info))
 	}
 
-	return token, nil
+	return tokens, rows.Err()
 }
 
-func (d *MySQL) SaveDeviceTokenByKey(key, token string) (string, error) {
+func (d *MySQL) SaveDeviceTokenByKey(key string, token common.TokenInfo) (string, error) {
 	if key == "" {
 		// Generate a new UUID as the deviceKey when a new device register
 		key = shortuuid.New()
 	}
-	rawString := fmt.Sprintf("INSERT INTO `%s` (`key`,`token`) VALUES (?,?) ON DUPLICATE KEY UPDATE `token`=?", common.LocalConfig.System.Name)
+	rawString := fmt.Sprintf("INSERT IGNORE INTO `%s` (`key`,`token`) VALUES (?,'')", common.LocalConfig.System.Name)
 
-	_, err := mysqlDB.Exec(rawString, key, token, token)
+	_, err := mysqlDB.Exec(rawString, key)
 	if err != nil {
 		return "", err
 	}
+	if token.Token == "" {
+		return key, nil
+	}
+
+	tokens, err := d.DeviceTokensByKey(key)
+	if err != nil {
+		return "", err
+	}
+	tokens, removed := mergeDeviceToken(tokens, token)
+	info, err := json.Marshal(tokens[len(tokens)-1])
+	if err != nil {
+		return "", err
+	}
+
+	rawString = fmt.Sprintf("INSERT INTO `%s` (`key`,`token`,`info`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE `info`=?", tokenTable())
+	if _, err = mysqlDB.Exec(rawString, key, token.Token, info, info); err != nil {
+		return "", err
+	}
+	for _, t := range removed {
+		if err = d.RemoveDeviceTokenByKey(key, t); err != nil {
+			return "", err
+		}
+	}
 
 	return key, nil
 }
 
+func (d *MySQL) RemoveDeviceTokenByKey(key, token string) error {
+	rawString := fmt.Sprintf("DELETE FROM `%s` WHERE `key`=? AND `token`=?", tokenTable())
+	result, err := mysqlDB.Exec(rawString, key, token)
+	if err != nil {
+		return err
+	}
+	// 同时清空主表中旧版本的 token，避免下次启动时重新迁移
+	rawString = fmt.Sprintf("UPDATE `%s` SET `token`='' WHERE `key`=? AND `token`=?", common.LocalConfig.System.Name)
+	if _, err = mysqlDB.Exec(rawString, key, token); err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("device token not found in key [%s]", key)
+	}
+	return nil
+}
+
 func (d *MySQL) Close() error {
 	return mysqlDB.Close()
 }
@@ -94,3 +207,82 @@ func (d *MySQL) KeyExists(key string) bool {
 
 	return exists
 }
+
+func (d *MySQL) DeleteDeviceToken(token string) (int, error) {
+	rawString := fmt.Sprintf("DELETE FROM `%s` WHERE `token`=?", tokenTable())
+	result, err := mysqlDB.Exec(rawString, token)
+	if err != nil {
+		return 0, err
+	}
+	rawString = fmt.Sprintf("UPDATE `%s` SET `token`='' WHERE `token`=?", common.LocalConfig.System.Name)
+	if _, err = mysqlDB.Exec(rawString, token); err != nil {
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+func (d *MySQL) DeleteDeviceByKey(key string) error {
+	rawString := fmt.Sprintf("DELETE FROM `%s` WHERE `key`=?", tokenTable())
+	if _, err := mysqlDB.Exec(rawString, key); err != nil {
+		return err
+	}
+
+	rawString = fmt.Sprintf("DELETE FROM `%s` WHERE `key`=?", common.LocalConfig.System.Name)
+	result, err := mysqlDB.Exec(rawString, key)
+	if err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("device key [%s] not found", key)
+	}
+	return nil
+}
+
+func (d *MySQL) SaveRecord(bucket, id string, data []byte) error {
+	rawString := fmt.Sprintf("INSERT INTO `%s` (`bucket`,`id`,`data`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE `data`=?", recordTable())
+	_, err := mysqlDB.Exec(rawString, bucket, id, data, data)
+	return err
+}
+
+func (d *MySQL) RecordByID(bucket, id string) ([]byte, error) {
+	var data []byte
+	rawString := fmt.Sprintf("SELECT `data` FROM `%s` WHERE `bucket`=? AND `id`=?", recordTable())
+	err := mysqlDB.QueryRow(rawString, bucket, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (d *MySQL) DeleteRecord(bucket, id string) error {
+	rawString := fmt.Sprintf("DELETE FROM `%s` WHERE `bucket`=? AND `id`=?", recordTable())
+	_, err := mysqlDB.Exec(rawString, bucket, id)
+	return err
+}
+
+func (d *MySQL) Records(bucket string) ([]Record, error) {
+	rawString := fmt.Sprintf("SELECT `id`,`data` FROM `%s` WHERE `bucket`=? ORDER BY `id`", recordTable())
+	rows, err := mysqlDB.Query(rawString, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		if err = rows.Scan(&record.ID, &record.Data); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
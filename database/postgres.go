@@ -0,0 +1,288 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/sunvc/NoLets/common"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+type PostgreSQL struct {
+}
+
+var postgresDB *sql.DB
+
+func CreatePostgresSchema() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`, common.LocalConfig.System.Name) +
+		`    "id" SERIAL PRIMARY KEY,` +
+		`    "key" VARCHAR(255) NOT NULL UNIQUE,` +
+		`    "token" VARCHAR(255) NOT NULL` +
+		`)`
+}
+
+// CreatePostgresTokenSchema 每个 key 下的多个 token，info 保存 token 元数据的 JSON
+func CreatePostgresTokenSchema() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`, tokenTable()) +
+		`    "id" SERIAL PRIMARY KEY,` +
+		`    "key" VARCHAR(255) NOT NULL,` +
+		`    "token" VARCHAR(255) NOT NULL,` +
+		`    "info" TEXT NOT NULL,` +
+		`    UNIQUE ("key","token")` +
+		`)`
+}
+
+// CreatePostgresTokenIndex 按 token 删除失效设备时使用的索引
+func CreatePostgresTokenIndex() string {
+	return fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_token" ON "%s" ("token")`, tokenTable(), tokenTable())
+}
+
+// CreatePostgresLegacyTokenIndex 主表中旧版本 token 字段的索引，删除 token 时同时清空该字段
+func CreatePostgresLegacyTokenIndex() string {
+	name := common.LocalConfig.System.Name
+	return fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_token" ON "%s" ("token")`, name, name)
+}
+
+// CreatePostgresRecordSchema 通用记录表，data 保存 JSON
+func CreatePostgresRecordSchema() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`, recordTable()) +
+		`    "bucket" VARCHAR(64) NOT NULL,` +
+		`    "id" VARCHAR(255) NOT NULL,` +
+		`    "data" TEXT NOT NULL,` +
+		`    PRIMARY KEY ("bucket","id")` +
+		`)`
+}
+
+// migratePostgresTokens 将旧版本保存在主表中的单个 token 复制到 token 表，可以重复执行
+// 主表中的 token 保留，回退到旧版本时仍然可用
+func migratePostgresTokens(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO "%s" ("key","token","info") SELECT "key","token",json_build_object('token',"token")::text FROM "%s" WHERE "token"<>'' ON CONFLICT ("key","token") DO NOTHING`,
+		tokenTable(), common.LocalConfig.System.Name))
+	return err
+}
+
+// redactPostgresDSN 隐藏 DSN 中的密码，用于日志输出
+func redactPostgresDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "postgres://"
+	}
+	return u.Redacted()
+}
+
+func NewPostgreSQL(dsn string) (Database, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to open database connection (%s)", redactPostgresDSN(dsn)), err)
+		return nil, err
+	}
+
+	for _, dbSchema := range []string{CreatePostgresSchema(), CreatePostgresTokenSchema(), CreatePostgresRecordSchema(),
+		CreatePostgresTokenIndex(), CreatePostgresLegacyTokenIndex()} {
+		if _, err = db.Exec(dbSchema); err != nil {
+			log.Println(fmt.Sprintf("failed to init database schema(%s)", dbSchema), err)
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	if err = migratePostgresTokens(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%w: %v", ErrMigrateTokens, err)
+	}
+
+	postgresDB = db
+	return &PostgreSQL{}, nil
+}
+
+func (d *PostgreSQL) CountAll() (int, error) {
+	var count int
+	rawString := fmt.Sprintf(`SELECT COUNT(1) FROM "%s"`, common.LocalConfig.System.Name)
+	err := postgresDB.QueryRow(rawString).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (d *PostgreSQL) DeviceTokensByKey(key string) ([]common.TokenInfo, error) {
+	if !d.KeyExists(key) {
+		return nil, fmt.Errorf("failed to get [%s] device token from database", key)
+	}
+
+	rawString := fmt.Sprintf(`SELECT "token","info" FROM "%s" WHERE "key"=$1 ORDER BY "id"`, tokenTable())
+	rows, err := postgresDB.Query(rawString, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var tokens []common.TokenInfo
+	for rows.Next() {
+		var token string
+		var info []byte
+		if err = rows.Scan(&token, &info); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, decodeDeviceToken(token, info))
+	}
+
+	return tokens, rows.Err()
+}
+
+func (d *PostgreSQL) SaveDeviceTokenByKey(key string, token common.TokenInfo) (string, error) {
+	if key == "" {
+		// Generate a new UUID as the deviceKey when a new device register
+		key = shortuuid.New()
+	}
+	rawString := fmt.Sprintf(`INSERT INTO "%s" ("key","token") VALUES ($1,'') ON CONFLICT ("key") DO NOTHING`, common.LocalConfig.System.Name)
+
+	_, err := postgresDB.Exec(rawString, key)
+	if err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		return key, nil
+	}
+
+	tokens, err := d.DeviceTokensByKey(key)
+	if err != nil {
+		return "", err
+	}
+	tokens, removed := mergeDeviceToken(tokens, token)
+	info, err := json.Marshal(tokens[len(tokens)-1])
+	if err != nil {
+		return "", err
+	}
+
+	rawString = fmt.Sprintf(`INSERT INTO "%s" ("key","token","info") VALUES ($1,$2,$3) ON CONFLICT ("key","token") DO UPDATE SET "info"=EXCLUDED."info"`, tokenTable())
+	if _, err = postgresDB.Exec(rawString, key, token.Token, string(info)); err != nil {
+		return "", err
+	}
+	for _, t := range removed {
+		if err = d.RemoveDeviceTokenByKey(key, t); err != nil {
+			return "", err
+		}
+	}
+
+	return key, nil
+}
+
+func (d *PostgreSQL) RemoveDeviceTokenByKey(key, token string) error {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "key"=$1 AND "token"=$2`, tokenTable())
+	result, err := postgresDB.Exec(rawString, key, token)
+	if err != nil {
+		return err
+	}
+	// 同时清空主表中旧版本的 token，避免下次启动时重新迁移
+	rawString = fmt.Sprintf(`UPDATE "%s" SET "token"='' WHERE "key"=$1 AND "token"=$2`, common.LocalConfig.System.Name)
+	if _, err = postgresDB.Exec(rawString, key, token); err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("device token not found in key [%s]", key)
+	}
+	return nil
+}
+
+func (d *PostgreSQL) Close() error {
+	return postgresDB.Close()
+}
+
+func (d *PostgreSQL) KeyExists(key string) bool {
+	var exists bool
+	rawString := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM "%s" WHERE "key"=$1)`, common.LocalConfig.System.Name)
+	err := postgresDB.QueryRow(rawString, key).Scan(&exists)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to check key existence: %v", err))
+		return false
+	}
+
+	return exists
+}
+
+func (d *PostgreSQL) DeleteDeviceToken(token string) (int, error) {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "token"=$1`, tokenTable())
+	result, err := postgresDB.Exec(rawString, token)
+	if err != nil {
+		return 0, err
+	}
+	rawString = fmt.Sprintf(`UPDATE "%s" SET "token"='' WHERE "token"=$1`, common.LocalConfig.System.Name)
+	if _, err = postgresDB.Exec(rawString, token); err != nil {
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+func (d *PostgreSQL) DeleteDeviceByKey(key string) error {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "key"=$1`, tokenTable())
+	if _, err := postgresDB.Exec(rawString, key); err != nil {
+		return err
+	}
+
+	rawString = fmt.Sprintf(`DELETE FROM "%s" WHERE "key"=$1`, common.LocalConfig.System.Name)
+	result, err := postgresDB.Exec(rawString, key)
+	if err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("device key [%s] not found", key)
+	}
+	return nil
+}
+
+func (d *PostgreSQL) SaveRecord(bucket, id string, data []byte) error {
+	rawString := fmt.Sprintf(`INSERT INTO "%s" ("bucket","id","data") VALUES ($1,$2,$3) ON CONFLICT ("bucket","id") DO UPDATE SET "data"=EXCLUDED."data"`, recordTable())
+	_, err := postgresDB.Exec(rawString, bucket, id, string(data))
+	return err
+}
+
+func (d *PostgreSQL) RecordByID(bucket, id string) ([]byte, error) {
+	var data []byte
+	rawString := fmt.Sprintf(`SELECT "data" FROM "%s" WHERE "bucket"=$1 AND "id"=$2`, recordTable())
+	err := postgresDB.QueryRow(rawString, bucket, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (d *PostgreSQL) DeleteRecord(bucket, id string) error {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "bucket"=$1 AND "id"=$2`, recordTable())
+	_, err := postgresDB.Exec(rawString, bucket, id)
+	return err
+}
+
+func (d *PostgreSQL) Records(bucket string) ([]Record, error) {
+	rawString := fmt.Sprintf(`SELECT "id","data" FROM "%s" WHERE "bucket"=$1 ORDER BY "id"`, recordTable())
+	rows, err := postgresDB.Query(rawString, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		if err = rows.Scan(&record.ID, &record.Data); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
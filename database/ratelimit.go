@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+)
+
+// RateLimitStore 令牌桶计数的存储
+type RateLimitStore interface {
+	// Take 从 id 对应的桶中取出一个令牌，rate 为每秒补充的令牌数，burst 为桶容量
+	Take(id string, rate float64, burst int) (RateLimitResult, error)
+}
+
+// RateLimitResult 一次取令牌的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // 下一个令牌可用前需要等待的时间
+	Reset      time.Duration // 桶重新装满需要的时间
+}
+
+// NewRateLimitStore 默认在内存中计数，配置 rate_limit_shared 且使用 MySQL 时多个实例共享计数
+func NewRateLimitStore() RateLimitStore {
+	if common.LocalConfig.System.RateLimitShared {
+		if mysqlDB != nil {
+			if _, err := mysqlDB.Exec(CreateRateLimitSchema()); err == nil {
+				return &mysqlRateLimitStore{db: mysqlDB}
+			} else {
+				log.Println("failed to init rate limit schema", err)
+			}
+		}
+		log.Println("rate_limit_shared requires a MySQL dsn, using in-memory counters")
+	}
+	return &memoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+// CreateRateLimitSchema 共享限流计数表，updated 为毫秒时间戳
+func CreateRateLimitSchema() string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS  `%s` (", common.LocalConfig.System.Name+"_ratelimit") +
+		"    `id` VARCHAR(255) NOT NULL," +
+		"    `tokens` DOUBLE NOT NULL," +
+		"    `updated` BIGINT NOT NULL," +
+		"    PRIMARY KEY (`id`)," +
+		"    KEY `updated` (`updated`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	rate    float64
+	burst   int
+}
+
+// take 按经过的时间补充令牌后取出一个
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) RateLimitResult {
+	b.rate, b.burst = rate, burst
+	capacity := float64(burst)
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+	}
+	b.updated = now
+
+	result := RateLimitResult{Limit: burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((capacity - b.tokens) / rate * float64(time.Second))
+	return result
+}
+
+// full 桶是否已经补满，补满的桶和新建的桶没有区别，可以回收
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.updated).Seconds()*b.rate >= float64(b.burst)
+}
+
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func (s *memoryRateLimitStore) Take(id string, rate float64, burst int) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := s.buckets[id]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), updated: now}
+		s.buckets[id] = bucket
+	}
+	result := bucket.take(now, rate, burst)
+
+	// 每分钟清理一次已经补满的桶，避免大量 IP 和 key 占用内存
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+		for k, b := range s.buckets {
+			if b.full(now) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+	return result, nil
+}
+
+type mysqlRateLimitStore struct {
+	db        *sql.DB
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (s *mysqlRateLimitStore) Take(id string, rate float64, burst int) (RateLimitResult, error) {
+	table := common.LocalConfig.System.Name + "_ratelimit"
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(fmt.Sprintf("INSERT IGNORE INTO `%s` (`id`,`tokens`,`updated`) VALUES (?,?,?)", table),
+		id, float64(burst), now.UnixMilli())
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	var updated int64
+	bucket := &tokenBucket{}
+	err = tx.QueryRow(fmt.Sprintf("SELECT `tokens`,`updated` FROM `%s` WHERE `id`=? FOR UPDATE", table), id).
+		Scan(&bucket.tokens, &updated)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	bucket.updated = time.UnixMilli(updated)
+
+	result := bucket.take(now, rate, burst)
+	_, err = tx.Exec(fmt.Sprintf("UPDATE `%s` SET `tokens`=?,`updated`=? WHERE `id`=?", table),
+		bucket.tokens, now.UnixMilli(), id)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	// 定期删除长时间没有请求的记录
+	s.mu.Lock()
+	sweep := now.Sub(s.lastSweep) > time.Hour
+	if sweep {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if sweep {
+		_, err = s.db.Exec(fmt.Sprintf("DELETE FROM `%s` WHERE `updated`<?", table), now.Add(-time.Hour).UnixMilli())
+		if err != nil {
+			log.Println("failed to clean rate limit records", err)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,300 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sunvc/NoLets/common"
+
+	"github.com/lithammer/shortuuid/v3"
+	_ "modernc.org/sqlite"
+)
+
+type SQLite struct {
+}
+
+var sqliteDB *sql.DB
+
+func CreateSQLiteSchema() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`, common.LocalConfig.System.Name) +
+		`    "id" INTEGER PRIMARY KEY AUTOINCREMENT,` +
+		`    "key" TEXT NOT NULL UNIQUE,` +
+		`    "token" TEXT NOT NULL` +
+		`)`
+}
+
+// CreateSQLiteTokenSchema 每个 key 下的多个 token，info 保存 token 元数据的 JSON
+func CreateSQLiteTokenSchema() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`, tokenTable()) +
+		`    "id" INTEGER PRIMARY KEY AUTOINCREMENT,` +
+		`    "key" TEXT NOT NULL,` +
+		`    "token" TEXT NOT NULL,` +
+		`    "info" TEXT NOT NULL,` +
+		`    UNIQUE ("key","token")` +
+		`)`
+}
+
+// CreateSQLiteTokenIndex 按 token 删除失效设备时使用的索引
+func CreateSQLiteTokenIndex() string {
+	return fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_token" ON "%s" ("token")`, tokenTable(), tokenTable())
+}
+
+// CreateSQLiteLegacyTokenIndex 主表中旧版本 token 字段的索引，删除 token 时同时清空该字段
+func CreateSQLiteLegacyTokenIndex() string {
+	name := common.LocalConfig.System.Name
+	return fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_token" ON "%s" ("token")`, name, name)
+}
+
+// CreateSQLiteRecordSchema 通用记录表，data 保存 JSON
+func CreateSQLiteRecordSchema() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`, recordTable()) +
+		`    "bucket" TEXT NOT NULL,` +
+		`    "id" TEXT NOT NULL,` +
+		`    "data" TEXT NOT NULL,` +
+		`    PRIMARY KEY ("bucket","id")` +
+		`)`
+}
+
+// migrateSQLiteTokens 将旧版本保存在主表中的单个 token 复制到 token 表，可以重复执行
+// 主表中的 token 保留，回退到旧版本时仍然可用
+func migrateSQLiteTokens(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`INSERT OR IGNORE INTO "%s" ("key","token","info") SELECT "key","token",json_object('token',"token") FROM "%s" WHERE "token"<>''`,
+		tokenTable(), common.LocalConfig.System.Name))
+	return err
+}
+
+// sqlitePath 解析 sqlite://path 形式的 DSN，路径为空时使用数据目录
+func sqlitePath(dsn string) string {
+	path := strings.TrimPrefix(dsn, "sqlite://")
+	if path == "" {
+		path = common.BaseDir(common.LocalConfig.System.Name + ".sqlite")
+	}
+	return path
+}
+
+func NewSQLite(dsn string) (Database, error) {
+	path := sqlitePath(dsn)
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Println(fmt.Sprintf("failed to create database storage dir(%s): %v", dir, err))
+		}
+	}
+
+	// WAL 模式允许外部工具在服务运行时安全读取数据库
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to open database connection (%s)", path), err)
+		return nil, err
+	}
+	// SQLite 同一时间只允许一个写入者
+	db.SetMaxOpenConns(1)
+
+	for _, dbSchema := range []string{CreateSQLiteSchema(), CreateSQLiteTokenSchema(), CreateSQLiteRecordSchema(),
+		CreateSQLiteTokenIndex(), CreateSQLiteLegacyTokenIndex()} {
+		if _, err = db.Exec(dbSchema); err != nil {
+			log.Println(fmt.Sprintf("failed to init database schema(%s)", dbSchema), err)
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	if err = migrateSQLiteTokens(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%w: %v", ErrMigrateTokens, err)
+	}
+
+	sqliteDB = db
+	return &SQLite{}, nil
+}
+
+func (d *SQLite) CountAll() (int, error) {
+	var count int
+	rawString := fmt.Sprintf(`SELECT COUNT(1) FROM "%s"`, common.LocalConfig.System.Name)
+	err := sqliteDB.QueryRow(rawString).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (d *SQLite) DeviceTokensByKey(key string) ([]common.TokenInfo, error) {
+	if !d.KeyExists(key) {
+		return nil, fmt.Errorf("failed to get [%s] device token from database", key)
+	}
+
+	rawString := fmt.Sprintf(`SELECT "token","info" FROM "%s" WHERE "key"=? ORDER BY "id"`, tokenTable())
+	rows, err := sqliteDB.Query(rawString, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var tokens []common.TokenInfo
+	for rows.Next() {
+		var token string
+		var info []byte
+		if err = rows.Scan(&token, &info); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, decodeDeviceToken(token, info))
+	}
+
+	return tokens, rows.Err()
+}
+
+func (d *SQLite) SaveDeviceTokenByKey(key string, token common.TokenInfo) (string, error) {
+	if key == "" {
+		// Generate a new UUID as the deviceKey when a new device register
+		key = shortuuid.New()
+	}
+	rawString := fmt.Sprintf(`INSERT OR IGNORE INTO "%s" ("key","token") VALUES (?,'')`, common.LocalConfig.System.Name)
+
+	_, err := sqliteDB.Exec(rawString, key)
+	if err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		return key, nil
+	}
+
+	tokens, err := d.DeviceTokensByKey(key)
+	if err != nil {
+		return "", err
+	}
+	tokens, removed := mergeDeviceToken(tokens, token)
+	info, err := json.Marshal(tokens[len(tokens)-1])
+	if err != nil {
+		return "", err
+	}
+
+	rawString = fmt.Sprintf(`INSERT INTO "%s" ("key","token","info") VALUES (?,?,?) ON CONFLICT ("key","token") DO UPDATE SET "info"=excluded."info"`, tokenTable())
+	if _, err = sqliteDB.Exec(rawString, key, token.Token, string(info)); err != nil {
+		return "", err
+	}
+	for _, t := range removed {
+		if err = d.RemoveDeviceTokenByKey(key, t); err != nil {
+			return "", err
+		}
+	}
+
+	return key, nil
+}
+
+func (d *SQLite) RemoveDeviceTokenByKey(key, token string) error {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "key"=? AND "token"=?`, tokenTable())
+	result, err := sqliteDB.Exec(rawString, key, token)
+	if err != nil {
+		return err
+	}
+	// 同时清空主表中旧版本的 token，避免下次启动时重新迁移
+	rawString = fmt.Sprintf(`UPDATE "%s" SET "token"='' WHERE "key"=? AND "token"=?`, common.LocalConfig.System.Name)
+	if _, err = sqliteDB.Exec(rawString, key, token); err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("device token not found in key [%s]", key)
+	}
+	return nil
+}
+
+func (d *SQLite) Close() error {
+	return sqliteDB.Close()
+}
+
+func (d *SQLite) KeyExists(key string) bool {
+	var exists bool
+	rawString := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM "%s" WHERE "key"=?)`, common.LocalConfig.System.Name)
+	err := sqliteDB.QueryRow(rawString, key).Scan(&exists)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to check key existence: %v", err))
+		return false
+	}
+
+	return exists
+}
+
+func (d *SQLite) DeleteDeviceToken(token string) (int, error) {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "token"=?`, tokenTable())
+	result, err := sqliteDB.Exec(rawString, token)
+	if err != nil {
+		return 0, err
+	}
+	rawString = fmt.Sprintf(`UPDATE "%s" SET "token"='' WHERE "token"=?`, common.LocalConfig.System.Name)
+	if _, err = sqliteDB.Exec(rawString, token); err != nil {
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+func (d *SQLite) DeleteDeviceByKey(key string) error {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "key"=?`, tokenTable())
+	if _, err := sqliteDB.Exec(rawString, key); err != nil {
+		return err
+	}
+
+	rawString = fmt.Sprintf(`DELETE FROM "%s" WHERE "key"=?`, common.LocalConfig.System.Name)
+	result, err := sqliteDB.Exec(rawString, key)
+	if err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("device key [%s] not found", key)
+	}
+	return nil
+}
+
+func (d *SQLite) SaveRecord(bucket, id string, data []byte) error {
+	rawString := fmt.Sprintf(`INSERT INTO "%s" ("bucket","id","data") VALUES (?,?,?) ON CONFLICT ("bucket","id") DO UPDATE SET "data"=excluded."data"`, recordTable())
+	_, err := sqliteDB.Exec(rawString, bucket, id, string(data))
+	return err
+}
+
+func (d *SQLite) RecordByID(bucket, id string) ([]byte, error) {
+	var data []byte
+	rawString := fmt.Sprintf(`SELECT "data" FROM "%s" WHERE "bucket"=? AND "id"=?`, recordTable())
+	err := sqliteDB.QueryRow(rawString, bucket, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (d *SQLite) DeleteRecord(bucket, id string) error {
+	rawString := fmt.Sprintf(`DELETE FROM "%s" WHERE "bucket"=? AND "id"=?`, recordTable())
+	_, err := sqliteDB.Exec(rawString, bucket, id)
+	return err
+}
+
+func (d *SQLite) Records(bucket string) ([]Record, error) {
+	rawString := fmt.Sprintf(`SELECT "id","data" FROM "%s" WHERE "bucket"=? ORDER BY "id"`, recordTable())
+	rows, err := sqliteDB.Query(rawString, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		if err = rows.Scan(&record.ID, &record.Data); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
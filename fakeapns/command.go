@@ -0,0 +1,111 @@
+package fakeapns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Command nolets fake-apns 子命令
+// 不配置证书时使用 h2c，服务端以 --apns-host http://<listen> 连接
+// 配置了 fcm.credentials 时同时模拟 FCM，服务账号的 token_uri 需要指向 http://<listen>/token
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "fake-apns",
+		Usage: "Run a local APNs and FCM stand-in that verifies and records pushes",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "listen",
+				Usage:   "Listen address of the fake APNs server",
+				Sources: cli.EnvVars("NOLET_FAKE_APNS_LISTEN"),
+				Value:   "127.0.0.1:2197",
+			},
+			&cli.StringFlag{
+				Name:    "tls-cert",
+				Usage:   "TLS certificate, serves h2c when empty",
+				Sources: cli.EnvVars("NOLET_FAKE_APNS_TLS_CERT"),
+			},
+			&cli.StringFlag{
+				Name:    "tls-key",
+				Usage:   "TLS certificate private key",
+				Sources: cli.EnvVars("NOLET_FAKE_APNS_TLS_KEY"),
+			},
+			&cli.IntFlag{
+				Name:    "max-stored",
+				Usage:   "Maximum number of received pushes kept in memory, 0 keeps all",
+				Sources: cli.EnvVars("NOLET_FAKE_APNS_MAX_STORED"),
+				Value:   1000,
+			},
+			&cli.StringSliceFlag{
+				Name:  "fail",
+				Usage: "Reject pushes with an APNs reason or FCM errorCode, as reason or token=reason, e.g. Unregistered",
+			},
+		},
+		Action: func(ctx context.Context, command *cli.Command) error {
+			if configPath := command.String("config"); configPath != "" {
+				common.LocalConfig.SetConfig(configPath)
+			}
+
+			server := NewServer(common.AppleApps(), command.Int("max-stored"))
+			if fcm := common.LocalConfig.FCM; fcm.Credentials != "" {
+				account, err := common.ReadServiceAccount(fcm.Credentials)
+				if err != nil {
+					return fmt.Errorf("invalid fcm credentials: %v", err)
+				}
+				server.EnableFCM(account, fcm.ProjectID)
+			}
+			for _, value := range command.StringSlice("fail") {
+				if _, err := server.AddRule(ParseRule(value)); err != nil {
+					return fmt.Errorf("invalid --fail %q: %v", value, err)
+				}
+			}
+
+			return Run(ctx, server, command.String("listen"), command.String("tls-cert"), command.String("tls-key"))
+		},
+	}
+}
+
+// Run 启动模拟服务，ctx 结束时关闭
+func Run(ctx context.Context, server *Server, addr, certFile, keyFile string) error {
+	gin.SetMode(gin.ReleaseMode)
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           h2c.NewHandler(server.Handler(), &http2.Server{}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if certFile != "" && keyFile != "" {
+			log.Println(fmt.Sprintf("fake-apns listening on https://%s", addr))
+			err = httpServer.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Println(fmt.Sprintf("fake-apns listening on http://%s (h2c)", addr))
+			err = httpServer.ListenAndServe()
+		}
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return httpServer.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,263 @@
+package fakeapns
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sunvc/NoLets/common"
+)
+
+const (
+	// MaxFCMPayloadSize FCM 允许的最大消息内容
+	MaxFCMPayloadSize = 4096
+	// fcmTokenLifetime 签发的 access token 有效期
+	fcmTokenLifetime = time.Hour
+)
+
+// fcmReasonStatus FCM errorCode 对应的状态码
+var fcmReasonStatus = map[string]int{
+	"INVALID_ARGUMENT":       http.StatusBadRequest,
+	"THIRD_PARTY_AUTH_ERROR": http.StatusUnauthorized,
+	"SENDER_ID_MISMATCH":     http.StatusForbidden,
+	"UNREGISTERED":           http.StatusNotFound,
+	"QUOTA_EXCEEDED":         http.StatusTooManyRequests,
+	"INTERNAL":               http.StatusInternalServerError,
+	"UNAVAILABLE":            http.StatusServiceUnavailable,
+}
+
+// grpcStatus 状态码对应的 gRPC 状态，用于错误响应中的 status
+var grpcStatus = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "PERMISSION_DENIED",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+}
+
+// fcmTTL android.ttl 的格式，秒数加 s 后缀
+var fcmTTL = regexp.MustCompile(`^\d+(\.\d{1,9})?s$`)
+
+// fcmState FCM 模拟服务的服务账号和已签发的 access token
+type fcmState struct {
+	account      *common.ServiceAccount
+	projectID    string
+	accessTokens map[string]time.Time // access token → 过期时间
+}
+
+// fcmMessage 接收的消息中需要校验的字段
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Data         map[string]any    `json:"data"`
+	Notification map[string]string `json:"notification"`
+	Android      *struct {
+		CollapseKey string `json:"collapse_key"`
+		Priority    string `json:"priority"`
+		TTL         string `json:"ttl"`
+	} `json:"android"`
+}
+
+// EnableFCM 使用服务账号启用 FCM 模拟接口，projectID 为空时使用服务账号中的项目
+func (s *Server) EnableFCM(account *common.ServiceAccount, projectID string) {
+	if projectID == "" {
+		projectID = account.ProjectID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fcm = &fcmState{account: account, projectID: projectID, accessTokens: map[string]time.Time{}}
+}
+
+// FCMToken 处理 POST /token，校验服务账号签名的 JWT 后签发 access token
+func (s *Server) FCMToken(c *gin.Context) {
+	s.mu.Lock()
+	fcm := s.fcm
+	s.mu.Unlock()
+	if fcm == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "FCM is not enabled"})
+		return
+	}
+
+	if c.PostForm("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(c.PostForm("assertion"), claims, func(t *jwt.Token) (interface{}, error) {
+		return &fcm.account.Key().PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithAudience(fcm.account.TokenURI),
+		jwt.WithIssuer(fcm.account.ClientEmail),
+		jwt.WithExpirationRequired())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": err.Error()})
+		return
+	}
+	if scope, _ := claims["scope"].(string); !slices.Contains(strings.Fields(scope), common.FCMScope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope", "error_description": "missing " + common.FCMScope})
+		return
+	}
+
+	accessToken := randomID()
+	s.mu.Lock()
+	now := time.Now()
+	for t, expiresAt := range fcm.accessTokens {
+		if now.After(expiresAt) {
+			delete(fcm.accessTokens, t)
+		}
+	}
+	fcm.accessTokens[accessToken] = now.Add(fcmTokenLifetime)
+	s.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"expires_in":   int(fcmTokenLifetime.Seconds()),
+		"token_type":   "Bearer",
+	})
+}
+
+// checkFCMRequest 按 FCM 的规则校验请求，返回失败时的状态码和原因
+// 认证失败和项目不匹配时只有 gRPC 状态，没有 errorCode
+func (s *Server) checkFCMRequest(c *gin.Context, fcm *fcmState, n *Notification, body []byte) (int, string, string) {
+	bearer, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if expiresAt, ok := fcm.accessTokens[bearer]; !ok || time.Now().After(expiresAt) {
+		return http.StatusUnauthorized, "", "Request had invalid authentication credentials."
+	}
+	if c.Param("project") != fcm.projectID {
+		return http.StatusForbidden, "", fmt.Sprintf("Permission denied on project %s.", c.Param("project"))
+	}
+
+	var req struct {
+		Message *fcmMessage `json:"message"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Message == nil {
+		return http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid JSON payload received."
+	}
+	message := req.Message
+	n.Token = message.Token
+	if message.Token == "" {
+		return http.StatusBadRequest, "INVALID_ARGUMENT", "Recipient of the message is not set."
+	}
+	for key, value := range message.Data {
+		if _, ok := value.(string); !ok {
+			return http.StatusBadRequest, "INVALID_ARGUMENT", fmt.Sprintf("Invalid value at 'message.data[%s]', expected string.", key)
+		}
+	}
+	if android := message.Android; android != nil {
+		switch android.Priority {
+		case "", "normal", "high":
+		default:
+			return http.StatusBadRequest, "INVALID_ARGUMENT", fmt.Sprintf("Invalid value at 'message.android.priority': %s", android.Priority)
+		}
+		if android.TTL != "" && !fcmTTL.MatchString(android.TTL) {
+			return http.StatusBadRequest, "INVALID_ARGUMENT", fmt.Sprintf("Invalid value at 'message.android.ttl': %s", android.TTL)
+		}
+		n.CollapseID = android.CollapseKey
+		if android.Priority == "high" {
+			n.Priority = 10
+		} else if android.Priority == "normal" {
+			n.Priority = 5
+		}
+	}
+	if len(body) > MaxFCMPayloadSize {
+		return http.StatusBadRequest, "INVALID_ARGUMENT", "Message is too big."
+	}
+	return 0, "", ""
+}
+
+// FCMSend 处理 POST /v1/projects/:project/messages:send
+func (s *Server) FCMSend(c *gin.Context) {
+	if c.Param("action") != "/messages:send" {
+		fcmError(c, http.StatusNotFound, "", "Method not found.")
+		return
+	}
+
+	s.mu.Lock()
+	fcm := s.fcm
+	s.mu.Unlock()
+	if fcm == nil {
+		fcmError(c, http.StatusNotFound, "", "FCM is not enabled.")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxFCMPayloadSize*2))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	n := &Notification{
+		Platform:   common.PlatformAndroid,
+		MessageID:  randomID(),
+		Status:     http.StatusOK,
+		ReceivedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	status, reason, message := s.checkFCMRequest(c, fcm, n, body)
+	if status == 0 {
+		if rule := s.matchRule(common.PlatformAndroid, n.Token); rule != nil {
+			status, reason, message = rule.Status, rule.Reason, rule.Reason
+		}
+	}
+	if status != 0 {
+		n.Status, n.Reason = status, reason
+		if reason == "" {
+			n.Reason = grpcStatus[status]
+		}
+	}
+	// 只保存通过认证的请求
+	if status != http.StatusUnauthorized && status != http.StatusForbidden {
+		var req struct {
+			Message json.RawMessage `json:"message"`
+		}
+		if json.Unmarshal(body, &req) == nil {
+			n.Payload = req.Message
+		}
+		s.store(n)
+	}
+	s.mu.Unlock()
+
+	if n.Status == http.StatusOK {
+		c.JSON(http.StatusOK, gin.H{"name": fmt.Sprintf("projects/%s/messages/%s", fcm.projectID, n.MessageID)})
+		return
+	}
+
+	log.Println(fmt.Sprintf("fake-fcm rejected push to %s: %d %s", n.Token, n.Status, n.Reason))
+	fcmError(c, status, reason, message)
+}
+
+// fcmError 返回 Google API 格式的错误，reason 不为空时放入 FcmError 详情
+func fcmError(c *gin.Context, status int, reason, message string) {
+	res := gin.H{
+		"code":    status,
+		"message": message,
+		"status":  grpcStatus[status],
+	}
+	if reason != "" {
+		res["details"] = []gin.H{{
+			"@type":     "type.googleapis.com/google.firebase.fcm.v1.FcmError",
+			"errorCode": reason,
+		}}
+	}
+	c.JSON(status, gin.H{"error": res})
+}
+
+// randomID 生成 access token 和消息 ID
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
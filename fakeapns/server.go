@@ -0,0 +1,477 @@
+package fakeapns
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/apns2"
+	"github.com/sunvc/apns2/token"
+)
+
+// MaxPayloadSize APNs 允许的最大推送内容
+const MaxPayloadSize = 4096
+
+// topicSuffixes 同一个 App 下可以使用的其他 Topic
+var topicSuffixes = []string{"", ".voip", ".complication", ".push-type.liveactivity"}
+
+// reasonStatus APNs 返回原因对应的状态码
+var reasonStatus = map[string]int{
+	apns2.ReasonBadCollapseID:               http.StatusBadRequest,
+	apns2.ReasonBadDeviceToken:              http.StatusBadRequest,
+	apns2.ReasonBadExpirationDate:           http.StatusBadRequest,
+	apns2.ReasonBadMessageID:                http.StatusBadRequest,
+	apns2.ReasonBadPriority:                 http.StatusBadRequest,
+	apns2.ReasonBadTopic:                    http.StatusBadRequest,
+	apns2.ReasonDeviceTokenNotForTopic:      http.StatusBadRequest,
+	apns2.ReasonDuplicateHeaders:            http.StatusBadRequest,
+	apns2.ReasonIdleTimeout:                 http.StatusBadRequest,
+	apns2.ReasonInvalidPushType:             http.StatusBadRequest,
+	apns2.ReasonMissingDeviceToken:          http.StatusBadRequest,
+	apns2.ReasonMissingTopic:                http.StatusBadRequest,
+	apns2.ReasonPayloadEmpty:                http.StatusBadRequest,
+	apns2.ReasonTopicDisallowed:             http.StatusBadRequest,
+	apns2.ReasonBadCertificate:              http.StatusForbidden,
+	apns2.ReasonBadCertificateEnvironment:   http.StatusForbidden,
+	apns2.ReasonExpiredProviderToken:        http.StatusForbidden,
+	apns2.ReasonForbidden:                   http.StatusForbidden,
+	apns2.ReasonInvalidProviderToken:        http.StatusForbidden,
+	apns2.ReasonMissingProviderToken:        http.StatusForbidden,
+	apns2.ReasonBadPath:                     http.StatusNotFound,
+	apns2.ReasonMethodNotAllowed:            http.StatusMethodNotAllowed,
+	apns2.ReasonExpiredToken:                http.StatusGone,
+	apns2.ReasonUnregistered:                http.StatusGone,
+	apns2.ReasonPayloadTooLarge:             http.StatusRequestEntityTooLarge,
+	apns2.ReasonTooManyProviderTokenUpdates: http.StatusTooManyRequests,
+	apns2.ReasonTooManyRequests:             http.StatusTooManyRequests,
+	apns2.ReasonInternalServerError:         http.StatusInternalServerError,
+	apns2.ReasonServiceUnavailable:          http.StatusServiceUnavailable,
+	apns2.ReasonShutdown:                    http.StatusServiceUnavailable,
+}
+
+// Notification 收到的一条推送
+type Notification struct {
+	Platform   string          `json:"platform"`
+	ApnsID     string          `json:"apnsId,omitempty"`
+	MessageID  string          `json:"messageId,omitempty"` // FCM 消息 ID
+	Token      string          `json:"token"`
+	Topic      string          `json:"topic,omitempty"`
+	PushType   string          `json:"pushType,omitempty"`
+	Priority   int             `json:"priority,omitempty"`
+	Expiration int64           `json:"expiration,omitempty"`
+	CollapseID string          `json:"collapseId,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	Status     int             `json:"status"`
+	Reason     string          `json:"reason,omitempty"`
+	ReceivedAt time.Time       `json:"receivedAt"`
+}
+
+// Rule 让匹配的推送返回指定的错误，用于测试重试和失效 token 清理
+type Rule struct {
+	ID       string `json:"id"`
+	Token    string `json:"token,omitempty"`    // 为空时匹配所有 token
+	Platform string `json:"platform,omitempty"` // 为空时按 reason 推断，无法推断时匹配所有平台
+	Status   int    `json:"status,omitempty"`   // 为空时按 reason 推断
+	Reason   string `json:"reason"`
+	Count    int    `json:"count,omitempty"` // 剩余生效次数，0 表示一直生效
+}
+
+// providerKey 用于校验 JWT 的公钥
+type providerKey struct {
+	TeamID string
+	Key    *ecdsa.PublicKey
+}
+
+// Server 本地的 APNs 模拟服务，校验请求后保存收到的推送
+type Server struct {
+	mu            sync.Mutex
+	keys          map[string]providerKey // Key ID → 公钥
+	topics        map[string]bool        // 允许的 Topic
+	certTopics    map[string]bool        // 使用证书认证的 Topic，不需要 JWT
+	notifications []*Notification
+	maxStored     int
+	rules         []*Rule
+
+	fcm *fcmState // 未配置 FCM 服务账号时为 nil
+	// webSubscribers 通过 /fake/webpush/subscriptions 创建的浏览器订阅
+	webSubscribers map[string]*webSubscriber
+}
+
+// NewServer 使用配置中的 App 创建模拟服务，maxStored 为最多保存的推送数量
+func NewServer(apps []common.Apple, maxStored int) *Server {
+	s := &Server{
+		keys:       map[string]providerKey{},
+		topics:     map[string]bool{},
+		certTopics: map[string]bool{},
+		maxStored:  maxStored,
+	}
+
+	for _, app := range apps {
+		for _, suffix := range topicSuffixes {
+			s.topics[app.Topic+suffix] = true
+			if app.CertFile != "" {
+				s.certTopics[app.Topic+suffix] = true
+			}
+		}
+		if app.CertFile != "" {
+			continue
+		}
+
+		key, err := app.PrivateKey()
+		if err != nil {
+			log.Println(fmt.Sprintf("failed to read APNs auth key of %s: %v", app.ID(), err))
+			continue
+		}
+		authKey, err := token.AuthKeyFromBytes(key)
+		if err != nil {
+			log.Println(fmt.Sprintf("failed to load APNs auth key of %s: %v", app.ID(), err))
+			continue
+		}
+		s.keys[app.KeyID] = providerKey{TeamID: app.TeamID, Key: &authKey.PublicKey}
+	}
+	return s
+}
+
+// AddRule 添加错误规则，未指定状态码时按 reason 推断
+func (s *Server) AddRule(rule Rule) (*Rule, error) {
+	if rule.Reason == "" {
+		return nil, errors.New("reason is required")
+	}
+	apnsStatus, isAPNs := reasonStatus[rule.Reason]
+	fcmStatus, isFCM := fcmReasonStatus[rule.Reason]
+	if rule.Platform == "" {
+		if isAPNs {
+			rule.Platform = common.PlatformIOS
+		} else if isFCM {
+			rule.Platform = common.PlatformAndroid
+		}
+	}
+	if rule.Status == 0 {
+		switch {
+		case isAPNs:
+			rule.Status = apnsStatus
+		case isFCM:
+			rule.Status = fcmStatus
+		default:
+			return nil, fmt.Errorf("unknown reason %q, status is required", rule.Reason)
+		}
+	}
+	if rule.Status < 400 || rule.Status > 599 {
+		return nil, fmt.Errorf("invalid status %d", rule.Status)
+	}
+	if rule.Count < 0 {
+		return nil, fmt.Errorf("invalid count %d", rule.Count)
+	}
+	rule.ID = uuid.NewString()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, &rule)
+	return &rule, nil
+}
+
+// ParseRule 解析命令行中的错误规则，格式为 reason 或 token=reason
+func ParseRule(value string) Rule {
+	if i := strings.LastIndex(value, "="); i >= 0 {
+		return Rule{Token: strings.TrimSpace(value[:i]), Reason: strings.TrimSpace(value[i+1:])}
+	}
+	return Rule{Reason: strings.TrimSpace(value)}
+}
+
+// Rules 返回当前的错误规则
+func (s *Server) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := make([]Rule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// DeleteRule 删除错误规则，id 为空时删除全部
+func (s *Server) DeleteRule(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" {
+		s.rules = nil
+		return true
+	}
+	for i, rule := range s.rules {
+		if rule.ID == id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Notifications 返回收到的推送，token 不为空时只返回该 token 的推送，limit 大于 0 时只返回最近的 limit 条
+func (s *Server) Notifications(deviceToken string, limit int) []*Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []*Notification{}
+	for _, n := range s.notifications {
+		if deviceToken == "" || n.Token == deviceToken {
+			result = append(result, n)
+		}
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// ClearNotifications 清空收到的推送
+func (s *Server) ClearNotifications() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications = nil
+}
+
+// matchRule 返回第一个匹配平台和 token 的规则，并扣减剩余次数
+func (s *Server) matchRule(platform, deviceToken string) *Rule {
+	for i, rule := range s.rules {
+		if rule.Token != "" && rule.Token != deviceToken {
+			continue
+		}
+		if rule.Platform != "" && rule.Platform != platform {
+			continue
+		}
+		matched := *rule
+		if rule.Count > 0 {
+			rule.Count--
+			if rule.Count == 0 {
+				s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			}
+		}
+		return &matched
+	}
+	return nil
+}
+
+// store 保存推送，超过上限时丢弃最早的
+func (s *Server) store(n *Notification) {
+	s.notifications = append(s.notifications, n)
+	if s.maxStored > 0 && len(s.notifications) > s.maxStored {
+		s.notifications = s.notifications[len(s.notifications)-s.maxStored:]
+	}
+}
+
+// verifyProviderToken 校验 authorization 中的 JWT，返回失败时的 APNs 原因
+func (s *Server) verifyProviderToken(authorization, topic string) string {
+	if authorization == "" {
+		if s.certTopics[topic] {
+			return ""
+		}
+		return apns2.ReasonMissingProviderToken
+	}
+
+	bearer, ok := strings.CutPrefix(authorization, "bearer ")
+	if !ok {
+		return apns2.ReasonInvalidProviderToken
+	}
+
+	var key providerKey
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(bearer, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key, ok = s.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key.Key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}))
+	if err != nil {
+		return apns2.ReasonInvalidProviderToken
+	}
+
+	if iss, _ := claims.GetIssuer(); iss != key.TeamID {
+		return apns2.ReasonInvalidProviderToken
+	}
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil {
+		return apns2.ReasonInvalidProviderToken
+	}
+	if time.Since(iat.Time) > time.Hour {
+		return apns2.ReasonExpiredProviderToken
+	}
+	return ""
+}
+
+// checkRequest 按 APNs 的规则校验请求，返回失败时的原因
+func (s *Server) checkRequest(c *gin.Context, n *Notification, body []byte) string {
+	if n.Token == "" {
+		return apns2.ReasonMissingDeviceToken
+	}
+	if n.Topic == "" {
+		return apns2.ReasonMissingTopic
+	}
+	if reason := s.verifyProviderToken(c.GetHeader("authorization"), n.Topic); reason != "" {
+		return reason
+	}
+	if !s.topics[n.Topic] {
+		return apns2.ReasonTopicDisallowed
+	}
+	if _, err := hex.DecodeString(n.Token); err != nil || len(n.Token) < 64 {
+		return apns2.ReasonBadDeviceToken
+	}
+
+	if v := c.GetHeader("apns-priority"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || (p != 1 && p != 5 && p != 10) {
+			return apns2.ReasonBadPriority
+		}
+		n.Priority = p
+	}
+	if v := c.GetHeader("apns-expiration"); v != "" {
+		exp, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || exp < 0 {
+			return apns2.ReasonBadExpirationDate
+		}
+		n.Expiration = exp
+	}
+	if len(n.CollapseID) > 64 {
+		return apns2.ReasonBadCollapseID
+	}
+	switch apns2.EPushType(n.PushType) {
+	case apns2.PushTypeAlert, apns2.PushTypeBackground, apns2.PushTypeLocation, apns2.PushTypeVOIP,
+		apns2.PushTypeComplication, apns2.PushTypeFileProvider, apns2.PushTypeMDM, apns2.PushTypeLiveActivity:
+	default:
+		return apns2.ReasonInvalidPushType
+	}
+
+	if len(body) == 0 || !json.Valid(body) {
+		return apns2.ReasonPayloadEmpty
+	}
+	if len(body) > MaxPayloadSize {
+		return apns2.ReasonPayloadTooLarge
+	}
+	return ""
+}
+
+// Push 处理 POST /3/device/:token
+func (s *Server) Push(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxPayloadSize*2))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	n := &Notification{
+		Platform:   common.PlatformIOS,
+		ApnsID:     c.GetHeader("apns-id"),
+		Token:      c.Param("token"),
+		Topic:      c.GetHeader("apns-topic"),
+		PushType:   c.GetHeader("apns-push-type"),
+		CollapseID: c.GetHeader("apns-collapse-id"),
+		Status:     http.StatusOK,
+		ReceivedAt: time.Now().UTC(),
+	}
+	if n.ApnsID == "" {
+		n.ApnsID = uuid.NewString()
+	}
+	if json.Valid(body) {
+		n.Payload = body
+	}
+
+	s.mu.Lock()
+	if n.Reason = s.checkRequest(c, n, body); n.Reason != "" {
+		n.Status = reasonStatus[n.Reason]
+	} else if rule := s.matchRule(common.PlatformIOS, n.Token); rule != nil {
+		n.Status, n.Reason = rule.Status, rule.Reason
+	}
+	s.store(n)
+	s.mu.Unlock()
+
+	c.Header("apns-id", n.ApnsID)
+	if n.Status == http.StatusOK {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	log.Println(fmt.Sprintf("fake-apns rejected push to %s: %d %s", n.Token, n.Status, n.Reason))
+	res := gin.H{"reason": n.Reason}
+	if n.Status == http.StatusGone {
+		res["timestamp"] = n.ReceivedAt.UnixMilli()
+	}
+	c.JSON(n.Status, res)
+}
+
+// Handler 返回模拟服务的路由
+// POST /3/device/:token 接收推送，/fake 下为查看推送和配置错误规则的接口
+// 启用 FCM 时 POST /token 签发 access token，POST /v1/projects/:project/messages:send 接收 Android 推送
+// POST /webpush/:id 作为浏览器推送服务接收 Web Push，订阅通过 POST /fake/webpush/subscriptions 创建
+func (s *Server) Handler() http.Handler {
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+
+	engine.POST("/3/device/:token", s.Push)
+	engine.POST("/token", s.FCMToken)
+	engine.POST("/v1/projects/:project/*action", s.FCMSend)
+	engine.POST("/webpush/:id", s.WebPush)
+
+	api := engine.Group("/fake")
+	api.GET("/notifications", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		c.JSON(http.StatusOK, common.Success(s.Notifications(c.Query("token"), limit)))
+	})
+	api.DELETE("/notifications", func(c *gin.Context) {
+		s.ClearNotifications()
+		c.JSON(http.StatusOK, common.Success())
+	})
+	api.POST("/webpush/subscriptions", func(c *gin.Context) {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		sub, err := s.NewWebPushSubscription(scheme + "://" + c.Request.Host)
+		if err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusInternalServerError, "%v", err))
+			return
+		}
+		c.JSON(http.StatusOK, common.Success(sub))
+	})
+	api.GET("/rules", func(c *gin.Context) {
+		c.JSON(http.StatusOK, common.Success(s.Rules()))
+	})
+	api.POST("/rules", func(c *gin.Context) {
+		var rule Rule
+		if err := c.BindJSON(&rule); err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "failed to get rule: %v", err))
+			return
+		}
+		added, err := s.AddRule(rule)
+		if err != nil {
+			c.JSON(http.StatusOK, common.Failed(http.StatusBadRequest, "%v", err))
+			return
+		}
+		c.JSON(http.StatusOK, common.Success(added))
+	})
+	api.DELETE("/rules", func(c *gin.Context) {
+		s.DeleteRule("")
+		c.JSON(http.StatusOK, common.Success())
+	})
+	api.DELETE("/rules/:id", func(c *gin.Context) {
+		if !s.DeleteRule(c.Param("id")) {
+			c.JSON(http.StatusOK, common.Failed(http.StatusNotFound, "rule not found"))
+			return
+		}
+		c.JSON(http.StatusOK, common.Success())
+	})
+
+	return engine
+}
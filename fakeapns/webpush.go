@@ -0,0 +1,181 @@
+package fakeapns
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sunvc/NoLets/common"
+)
+
+// maxVAPIDLifetime RFC 8292 要求 VAPID JWT 的有效期不超过 24 小时
+const maxVAPIDLifetime = 24 * time.Hour
+
+// webSubscriber 模拟的浏览器订阅，保存解密需要的私钥和 auth secret
+type webSubscriber struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+// NewWebPushSubscription 模拟浏览器创建一个订阅，推送地址指向 baseURL 下的 /webpush/:id
+func (s *Server) NewWebPushSubscription(baseURL string) (*common.WebPushSubscription, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	auth := make([]byte, 16)
+	if _, err = rand.Read(auth); err != nil {
+		return nil, err
+	}
+
+	id := randomID()
+	s.mu.Lock()
+	if s.webSubscribers == nil {
+		s.webSubscribers = map[string]*webSubscriber{}
+	}
+	s.webSubscribers[id] = &webSubscriber{key: key, auth: auth}
+	s.mu.Unlock()
+
+	sub := &common.WebPushSubscription{Endpoint: strings.TrimSuffix(baseURL, "/") + "/webpush/" + id}
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(auth)
+	return sub, nil
+}
+
+// verifyVAPID 校验 vapid 认证头，aud 需要是推送地址的 origin
+func verifyVAPID(authorization, origin string) error {
+	params, ok := strings.CutPrefix(authorization, "vapid ")
+	if !ok {
+		return fmt.Errorf("missing vapid authorization")
+	}
+	var t, k string
+	for _, part := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			t = value
+		case "k":
+			k = value
+		}
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(k)
+	if err != nil {
+		return fmt.Errorf("invalid vapid public key")
+	}
+	publicKey, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), raw)
+	if err != nil {
+		return fmt.Errorf("invalid vapid public key")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(t, claims, func(*jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}),
+		jwt.WithAudience(origin),
+		jwt.WithExpirationRequired())
+	if err != nil {
+		return err
+	}
+	exp, _ := claims.GetExpirationTime()
+	if time.Until(exp.Time) > maxVAPIDLifetime {
+		return fmt.Errorf("vapid token expires more than 24 hours in the future")
+	}
+	if sub, _ := claims.GetSubject(); !strings.HasPrefix(sub, "mailto:") && !strings.HasPrefix(sub, "https:") {
+		return fmt.Errorf("vapid sub must be a mailto: or https: URL")
+	}
+	return nil
+}
+
+// checkWebPushRequest 按 RFC 8030 / 8291 / 8292 校验请求，返回失败时的状态码、原因和解密后的内容
+func (s *Server) checkWebPushRequest(c *gin.Context, subscriber *webSubscriber, n *Notification, body []byte) (int, string, []byte) {
+	if subscriber == nil {
+		return http.StatusGone, "subscription expired or unsubscribed", nil
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if err := verifyVAPID(c.GetHeader("Authorization"), scheme+"://"+c.Request.Host); err != nil {
+		return http.StatusUnauthorized, err.Error(), nil
+	}
+
+	ttl, err := strconv.ParseInt(c.GetHeader("TTL"), 10, 64)
+	if err != nil || ttl < 0 {
+		return http.StatusBadRequest, "missing or invalid TTL header", nil
+	}
+	n.Expiration = n.ReceivedAt.Unix() + ttl
+	switch c.GetHeader("Urgency") {
+	case "", "very-low", "low", "normal", "high":
+	default:
+		return http.StatusBadRequest, "invalid Urgency header", nil
+	}
+	n.Topic = c.GetHeader("Topic")
+
+	if c.GetHeader("Content-Encoding") != "aes128gcm" {
+		return http.StatusUnsupportedMediaType, "content encoding must be aes128gcm", nil
+	}
+	if len(body) > common.WebPushRecordSize {
+		return http.StatusRequestEntityTooLarge, "payload too large", nil
+	}
+	plaintext, err := common.DecryptWebPush(subscriber.key, subscriber.auth, body)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("failed to decrypt payload: %v", err), nil
+	}
+	return 0, "", plaintext
+}
+
+// WebPush 处理 POST /webpush/:id，解密后保存收到的推送
+func (s *Server) WebPush(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, common.WebPushRecordSize*2))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	n := &Notification{
+		Platform:   common.PlatformWeb,
+		MessageID:  randomID(),
+		Token:      c.Param("id"),
+		Status:     http.StatusCreated,
+		ReceivedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	status, reason, plaintext := s.checkWebPushRequest(c, s.webSubscribers[n.Token], n, body)
+	if status == 0 {
+		if rule := s.matchRule(common.PlatformWeb, n.Token); rule != nil {
+			status, reason = rule.Status, rule.Reason
+		}
+	}
+	if status != 0 {
+		n.Status, n.Reason = status, reason
+	}
+	if json.Valid(plaintext) {
+		n.Payload = plaintext
+	}
+	s.store(n)
+	s.mu.Unlock()
+
+	if n.Status == http.StatusCreated {
+		c.Header("Location", "/fake/webpush/messages/"+n.MessageID)
+		c.Status(http.StatusCreated)
+		return
+	}
+
+	log.Println(fmt.Sprintf("fake-webpush rejected push to %s: %d %s", n.Token, n.Status, n.Reason))
+	c.String(n.Status, n.Reason)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sunvc/NoLets/common"
 	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/fakeapns"
 	"github.com/sunvc/NoLets/push"
 	"github.com/sunvc/NoLets/router"
 	"github.com/urfave/cli/v3"
@@ -41,14 +42,22 @@ func main() {
 		Usage:   "Push Server For NoLet",
 		Flags:   common.Flags(),
 		Authors: []any{"to@uuneo.com"},
+		Commands: []*cli.Command{
+			fakeapns.Command(),
+		},
 		Action: func(_ context.Context, command *cli.Command) error {
 
-			if configPath := command.String("config"); configPath != "" {
+			configPath := command.String("config")
+			if configPath != "" {
 				common.LocalConfig.SetConfig(configPath)
 			}
 
 			common.SetDefaultVersionOrCommID(version, buildDate, commitID)
+			if err := common.CheckDeliveryDefaults(); err != nil {
+				log.Fatal(err)
+			}
 			database.InitDatabase()
+			common.TemplateLookup = push.TemplateByName
 
 			systemConfig := common.LocalConfig.System
 
@@ -60,12 +69,27 @@ func main() {
 			gin.ForceConsoleColor()
 
 			engine := gin.Default()
+			if err := router.SetupTrustedProxies(engine); err != nil {
+				log.Fatal(err)
+			}
 			engine.Use(router.Verification())
 
 			tmpl := template.Must(template.New("").ParseFS(staticFS, "static/*.html"))
 			engine.SetHTMLTemplate(tmpl)
 
 			push.CreateAPNSClient(systemConfig.MaxAPNSClientCount)
+			if err := push.CreateFCMClient(); err != nil {
+				log.Fatal(err)
+			}
+			if err := push.CreateWebPushClient(); err != nil {
+				log.Fatal(err)
+			}
+			push.StartCredentialWatcher(ctxOut, configPath)
+			push.StartQueue(ctxOut)
+			push.StartScheduler(ctxOut)
+			push.StartCron(ctxOut)
+			push.StartMessageCleaner(ctxOut)
+			push.StartIdempotencyCleaner(ctxOut)
 
 			router.SetupRouter(engine)
 
@@ -127,5 +151,7 @@ func main() {
 		},
 	}
 
-	_ = app.Run(context.Background(), os.Args)
+	if err := app.Run(ctxOut, os.Args); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,296 @@
+package push
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sunvc/apns2"
+	"golang.org/x/net/http2"
+)
+
+// ErrPoolBusy 连接池中所有连接的并发流都已占满，等待超时
+var ErrPoolBusy = errors.New("all APNs connections are busy")
+
+const (
+	// poolAcquireTimeout 等待空闲流的最长时间
+	poolAcquireTimeout = 10 * time.Second
+	// minReconnectInterval 同一个连接两次重建之间的最短间隔，避免网络故障时反复重建
+	minReconnectInterval = time.Second
+	// drainTimeout 重建后等待旧连接上的请求完成的最长时间
+	drainTimeout = time.Minute
+)
+
+// apnsConn 连接池中的一个连接，每个连接使用独立的 HTTP/2 transport
+type apnsConn struct {
+	index     int
+	client    *apns2.Client
+	transport *http2.Transport
+	created   time.Time
+
+	inflight atomic.Int64
+	sent     atomic.Int64
+	failed   atomic.Int64
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// ConnStats 单个连接的统计
+type ConnStats struct {
+	Index       int        `json:"index"`
+	Inflight    int64      `json:"inflight"`
+	Sent        int64      `json:"sent"`
+	Failed      int64      `json:"failed"`
+	Created     time.Time  `json:"created"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// PoolStats 一个 App 在一个 APNs 环境下的连接池统计
+type PoolStats struct {
+	App        string      `json:"app"`
+	Host       string      `json:"host"`
+	MaxStreams int         `json:"maxStreams"`
+	Waiting    int64       `json:"waiting"`
+	Reconnects int64       `json:"reconnects"`
+	Conns      []ConnStats `json:"conns"`
+}
+
+// ClientPool 一个 App 在一个 APNs 环境下的连接池
+// 每次推送选择并发流最少的连接，从轮询位置开始比较，负载相同时依次使用各个连接
+// 每个连接的并发流不超过 maxStreams，全部占满时等待
+// 连接断开、收到 GOAWAY 或 APNs 返回 Shutdown / IdleTimeout 时重建该连接
+type ClientPool struct {
+	app        string
+	host       string
+	maxStreams int
+	newClient  func() (*apns2.Client, *http2.Transport)
+
+	slots      chan struct{}
+	next       atomic.Uint64
+	waiting    atomic.Int64
+	reconnects atomic.Int64
+
+	mu    sync.RWMutex
+	conns []*apnsConn
+}
+
+// newClientPool 创建连接池，连接在第一次推送时才会建立
+func newClientPool(app, host string, size, maxStreams int, newClient func() (*apns2.Client, *http2.Transport)) *ClientPool {
+	size = max(size, 1)
+	maxStreams = max(maxStreams, 1)
+
+	p := &ClientPool{
+		app:        app,
+		host:       host,
+		maxStreams: maxStreams,
+		newClient:  newClient,
+		slots:      make(chan struct{}, size*maxStreams),
+		conns:      make([]*apnsConn, size),
+	}
+	for i := range p.conns {
+		p.conns[i] = p.dial(i)
+	}
+	return p
+}
+
+func (p *ClientPool) dial(index int) *apnsConn {
+	client, transport := p.newClient()
+	return &apnsConn{index: index, client: client, transport: transport, created: time.Now()}
+}
+
+// Push 通过连接池发送通知
+// 收到 GOAWAY 时 APNs 没有处理该请求，重建连接后重试一次
+func (p *ClientPool) Push(n *apns2.Notification) (*apns2.Response, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := p.acquire()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := conn.client.Push(n)
+		p.release(conn)
+		conn.record(resp, err)
+
+		switch {
+		case err != nil && isConnError(err):
+			p.reconnect(conn, errorReason(err))
+			if attempt == 0 && isGoAway(err) {
+				continue
+			}
+		case resp != nil && (resp.Reason == apns2.ReasonShutdown || resp.Reason == apns2.ReasonIdleTimeout):
+			p.reconnect(conn, resp.Reason)
+		}
+		return resp, err
+	}
+}
+
+// acquire 占用一个并发流，返回负载最小的连接
+func (p *ClientPool) acquire() (*apnsConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		p.waiting.Add(1)
+		timer := time.NewTimer(poolAcquireTimeout)
+		defer timer.Stop()
+		select {
+		case p.slots <- struct{}{}:
+			p.waiting.Add(-1)
+		case <-timer.C:
+			p.waiting.Add(-1)
+			return nil, ErrPoolBusy
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	start := int(p.next.Add(1) % uint64(len(p.conns)))
+	var best *apnsConn
+	for i := range p.conns {
+		conn := p.conns[(start+i)%len(p.conns)]
+		if best == nil || conn.inflight.Load() < best.inflight.Load() {
+			best = conn
+		}
+	}
+	best.inflight.Add(1)
+	return best, nil
+}
+
+func (p *ClientPool) release(conn *apnsConn) {
+	conn.inflight.Add(-1)
+	<-p.slots
+}
+
+// reconnect 使用新的 transport 替换连接，旧连接上的请求完成后关闭
+func (p *ClientPool) reconnect(old *apnsConn, reason string) {
+	p.mu.Lock()
+	if p.conns[old.index] != old || time.Since(old.created) < minReconnectInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.conns[old.index] = p.dial(old.index)
+	p.mu.Unlock()
+
+	p.reconnects.Add(1)
+	log.Println(fmt.Sprintf("reconnecting APNs connection %d of %s (%s): %s", old.index, p.app, p.host, reason))
+	go drain(old)
+}
+
+// Close 等待连接上的请求完成后关闭所有连接
+func (p *ClientPool) Close() {
+	p.mu.RLock()
+	conns := slices.Clone(p.conns)
+	p.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			drain(conn)
+		}()
+	}
+	wg.Wait()
+}
+
+// Stats 返回连接池的统计
+func (p *ClientPool) Stats() PoolStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	stats := PoolStats{
+		App:        p.app,
+		Host:       p.host,
+		MaxStreams: p.maxStreams,
+		Waiting:    p.waiting.Load(),
+		Reconnects: p.reconnects.Load(),
+		Conns:      make([]ConnStats, 0, len(p.conns)),
+	}
+	for _, conn := range p.conns {
+		stats.Conns = append(stats.Conns, conn.stats())
+	}
+	return stats
+}
+
+// drain 等待连接上的请求完成后关闭连接
+func drain(conn *apnsConn) {
+	deadline := time.Now().Add(drainTimeout)
+	for conn.inflight.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	conn.transport.CloseIdleConnections()
+}
+
+func (c *apnsConn) record(resp *apns2.Response, err error) {
+	if err == nil && resp != nil && resp.StatusCode == 200 {
+		c.sent.Add(1)
+		return
+	}
+
+	c.failed.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.lastError = errorReason(err)
+	} else if resp != nil {
+		c.lastError = fmt.Sprintf("%d %s", resp.StatusCode, resp.Reason)
+	}
+	c.lastErrorAt = time.Now()
+}
+
+func (c *apnsConn) stats() ConnStats {
+	stats := ConnStats{
+		Index:    c.index,
+		Inflight: c.inflight.Load(),
+		Sent:     c.sent.Load(),
+		Failed:   c.failed.Load(),
+		Created:  c.created,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastError != "" {
+		at := c.lastErrorAt
+		stats.LastError = c.lastError
+		stats.LastErrorAt = &at
+	}
+	return stats
+}
+
+// isGoAway 服务端发送了 GOAWAY，请求没有被处理
+func isGoAway(err error) bool {
+	var goAway http2.GoAwayError
+	return errors.As(err, &goAway)
+}
+
+// isConnError 判断错误是否由连接失效引起，需要重建连接
+func isConnError(err error) bool {
+	if isGoAway(err) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "client connection lost") ||
+		strings.Contains(msg, "client connection force closed") ||
+		strings.Contains(msg, "connection reset")
+}
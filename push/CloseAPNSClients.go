@@ -2,32 +2,32 @@ package push
 
 import (
 	"log"
-
-	"golang.org/x/net/http2"
+	"sync"
 )
 
 // CloseAPNSClients 关闭所有APNS客户端资源
+// 连接池在锁外并行关闭，等待请求完成期间不会阻塞推送和凭证重新加载
 func CloseAPNSClients() {
-	// 关闭channel并清理资源
-	if CLIENTS != nil {
-		// 尝试关闭所有客户端连接
-		clientCount := len(CLIENTS)
-		for i := 0; i < clientCount; i++ {
-			select {
-			case client := <-CLIENTS:
-				// 如果客户端有需要特别关闭的资源，可以在这里处理
-				// 例如关闭HTTP客户端的连接池等
-				if client != nil && client.HTTPClient != nil && client.HTTPClient.Transport != nil {
-					// 尝试关闭transport
-					if transport, ok := client.HTTPClient.Transport.(*http2.Transport); ok && transport != nil {
-						transport.CloseIdleConnections()
-					}
-				}
-			default:
-				// channel已空
-				break
-			}
+	clientsMu.RLock()
+	var pools []*ClientPool
+	for _, appPools := range CLIENTS {
+		for _, pool := range appPools {
+			pools = append(pools, pool)
+		}
+	}
+	clientsMu.RUnlock()
+
+	if len(pools) > 0 {
+		// 关闭所有连接池，正在进行的请求完成后关闭连接
+		var wg sync.WaitGroup
+		for _, pool := range pools {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pool.Close()
+			}()
 		}
+		wg.Wait()
 
 		// 记录关闭信息
 		log.Println("All APNS clients have been closed")
@@ -5,31 +5,44 @@ import (
 	"crypto/x509"
 	"fmt"
 	"log"
+	"maps"
 	"net"
 	"net/http"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sunvc/NoLets/common"
 	"github.com/sunvc/apns2"
+	"github.com/sunvc/apns2/certificate"
 	"github.com/sunvc/apns2/token"
 	"golang.org/x/net/context"
 	"golang.org/x/net/http2"
 )
 
 var (
-	CLIENTS = make(chan *apns2.Client, 1)
+	// CLIENTS 每个 App 按 APNs 主机区分的连接池，开发环境与生产环境各一个
+	CLIENTS   = map[string]map[string]*ClientPool{}
+	clientsMu sync.RWMutex
+
+	// apnsCertExpiry 使用证书认证的 App 的证书过期时间
+	apnsCertExpiry = map[string]time.Time{}
+
+	// credentialFingerprints 创建连接池时每个 App 的凭证指纹，用于判断凭证是否变化
+	credentialFingerprints = map[string]string{}
+
+	poolSize int
+	rootCAs  *x509.CertPool
 )
 
 func CreateAPNSClient(maxClientCount int) {
 
-	CLIENTS = make(chan *apns2.Client, min(runtime.NumCPU(), maxClientCount))
+	poolSize = min(runtime.NumCPU(), maxClientCount)
 
-	authKey, err := token.AuthKeyFromBytes([]byte(common.LocalConfig.Apple.ApnsPrivateKey))
-	if err != nil {
-		log.Println(fmt.Sprintf("failed to create APNS auth key: %v", err))
-	}
-
-	var rootCAs *x509.CertPool
+	var err error
 
 	system := func() string { return runtime.GOOS }()
 
@@ -39,6 +52,7 @@ func CreateAPNSClient(maxClientCount int) {
 		rootCAs, err = x509.SystemCertPool()
 		if err != nil {
 			log.Println(fmt.Sprintf("failed to get rootCAs: %v", err))
+			rootCAs = x509.NewCertPool()
 		}
 	}
 
@@ -46,29 +60,136 @@ func CreateAPNSClient(maxClientCount int) {
 		rootCAs.AppendCertsFromPEM([]byte(ca))
 	}
 
-	for i := 0; i < min(runtime.NumCPU(), maxClientCount); i++ {
-		CLIENTS <- &apns2.Client{
-			Token: &token.Token{
-				AuthKey: authKey,
-				KeyID:   common.LocalConfig.Apple.KeyID,
-				TeamID:  common.LocalConfig.Apple.TeamID,
-			},
-			HTTPClient: &http.Client{
-				Transport: &http2.Transport{
-					DialTLSContext:  DialTLSContext,
-					TLSClientConfig: &tls.Config{RootCAs: rootCAs},
+	for _, app := range common.AppleApps() {
+		setAppPools(app.ID(), createAppClients(app), credentialFingerprint(app))
+	}
+	RegisterProvider(apnsProvider{})
+}
+
+// clientPool 返回 App 在 APNs 主机下的连接池
+func clientPool(app, host string) (*ClientPool, bool) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	pool, ok := CLIENTS[app][host]
+	return pool, ok
+}
+
+// setAppPools 替换 App 的连接池，旧连接池上的请求完成后关闭
+func setAppPools(app string, pools map[string]*ClientPool, fingerprint string) {
+	clientsMu.Lock()
+	old := CLIENTS[app]
+	CLIENTS[app] = pools
+	credentialFingerprints[app] = fingerprint
+	clientsMu.Unlock()
+
+	for _, pool := range old {
+		go pool.Close()
+	}
+}
+
+// PoolStatsAll 返回所有连接池的统计
+func PoolStatsAll() []PoolStats {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	stats := []PoolStats{}
+	for _, pools := range CLIENTS {
+		for _, pool := range pools {
+			stats = append(stats, pool.Stats())
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].App != stats[j].App {
+			return stats[i].App < stats[j].App
+		}
+		return stats[i].Host < stats[j].Host
+	})
+	return stats
+}
+
+// createAppClients 为一个 App 创建开发环境和生产环境的连接池
+// 同一个 App 的所有连接共用一个 token，避免频繁生成 token 被 APNs 拒绝
+func createAppClients(app common.Apple) map[string]*ClientPool {
+	// 配置了推送证书时使用证书认证，否则使用 .p8 私钥生成的 token
+	cert, err := loadAPNSCertificate(app)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to load APNs certificate of %s, falling back to token auth: %v", app.ID(), err))
+	}
+
+	var authToken *token.Token
+	if cert == nil {
+		authToken = &token.Token{KeyID: app.KeyID, TeamID: app.TeamID}
+		key, err := app.PrivateKey()
+		if err == nil {
+			authToken.AuthKey, err = token.AuthKeyFromBytes(key)
+		}
+		if err != nil {
+			log.Println(fmt.Sprintf("failed to create APNS auth key of %s: %v", app.ID(), err))
+		}
+	}
+
+	pools := map[string]*ClientPool{}
+	for _, host := range []string{apns2.HostDevelopment, apns2.HostProduction} {
+		newClient := func() (*apns2.Client, *http2.Transport) {
+			transport := newTransport(app.Host, rootCAs)
+			client := &apns2.Client{
+				HTTPClient: &http.Client{
+					Transport: transport,
+					Timeout:   apns2.HTTPClientTimeout,
 				},
-				Timeout: apns2.HTTPClientTimeout,
-			},
-			Host: selectPushMode(),
+				Host: host,
+			}
+			// 配置了 host 时开发环境和生产环境都发送到该地址
+			if app.Host != "" {
+				client.Host = strings.TrimSuffix(app.Host, "/")
+			}
+			if cert != nil {
+				client.Certificate = *cert
+				if transport.TLSClientConfig != nil {
+					transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+				}
+			} else {
+				client.Token = authToken
+			}
+			return client, transport
 		}
+
+		pools[host] = newClientPool(app.ID(), host, poolSize, common.LocalConfig.System.MaxAPNSStreams, newClient)
+		log.Println(fmt.Sprintf("init %s apns client of %s success...\n", host, app.ID()))
 	}
+	return pools
+}
 
-	log.Println(fmt.Sprintf("init %s apns client success...\n", selectPushMode()))
+// newTransport 创建 HTTP/2 传输，http:// 开头的 host 使用不加密的 h2c，用于连接本地的 fake-apns
+// 只使用一个连接并遵守服务端的并发流限制，空闲时发送 ping 检查连接，ping 超时后关闭连接
+func newTransport(host string, rootCAs *x509.CertPool) *http2.Transport {
+	transport := &http2.Transport{
+		DialTLSContext:             DialTLSContext,
+		TLSClientConfig:            &tls.Config{RootCAs: rootCAs},
+		StrictMaxConcurrentStreams: true,
+		ReadIdleTimeout:            apns2.ReadIdleTimeout,
+		PingTimeout:                apns2.ReadIdleTimeout,
+	}
+	if strings.HasPrefix(host, "http://") {
+		transport.AllowHTTP = true
+		transport.TLSClientConfig = nil
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: apns2.TLSDialTimeout, KeepAlive: apns2.TCPKeepAlive}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return transport
 }
 
-func selectPushMode() string {
-	if common.LocalConfig.Apple.Develop {
+// selectPushMode 根据 token 记录的环境选择 APNs 主机，未记录时使用 App 的 develop 配置
+func selectPushMode(app common.Apple, env string) string {
+	switch env {
+	case common.EnvDevelopment:
+		return apns2.HostDevelopment
+	case common.EnvProduction:
+		return apns2.HostProduction
+	}
+	if app.Develop {
 		return apns2.HostDevelopment
 	} else {
 		return apns2.HostProduction
@@ -88,3 +209,39 @@ func DialTLSContext(context context.Context, network, addr string, cfg *tls.Conf
 	return dialer.DialContext(context, network, addr)
 
 }
+
+// loadAPNSCertificate 读取 App 配置的 .p12 或 .pem 推送证书，未配置时返回 nil
+func loadAPNSCertificate(app common.Apple) (*tls.Certificate, error) {
+	if app.CertFile == "" {
+		return nil, nil
+	}
+
+	var cert tls.Certificate
+	var err error
+	switch strings.ToLower(filepath.Ext(app.CertFile)) {
+	case ".p12", ".pfx":
+		cert, err = certificate.FromP12File(app.CertFile, app.CertPassword)
+	default:
+		cert, err = certificate.FromPemFile(app.CertFile, app.CertPassword)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf != nil {
+		clientsMu.Lock()
+		apnsCertExpiry[app.ID()] = cert.Leaf.NotAfter
+		clientsMu.Unlock()
+		if time.Until(cert.Leaf.NotAfter) < 30*24*time.Hour {
+			log.Println(fmt.Sprintf("APNs certificate of %s expires at %s", app.ID(), cert.Leaf.NotAfter.Format(time.RFC3339)))
+		}
+	}
+	return &cert, nil
+}
+
+// CertificateExpiry 返回使用证书认证的 App 的证书过期时间
+func CertificateExpiry() map[string]time.Time {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	return maps.Clone(apnsCertExpiry)
+}
@@ -0,0 +1,238 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+)
+
+// CronBucket 周期任务保存的位置
+const CronBucket = "cron"
+
+// cronParser 标准五段 cron 表达式，支持 @daily 等描述符和 CRON_TZ= 前缀
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// cronMu 串行化周期任务的读写，避免调度器与接口同时修改同一任务
+var cronMu sync.Mutex
+
+// cronWake 任务变更后唤醒调度器重新计算等待时间
+var cronWake = make(chan struct{}, 1)
+
+// CronNext 校验 cron 表达式和时区，返回 after 之后的下一次触发时间
+// timeZone 为空时使用 system.time_zone
+func CronNext(spec, timeZone string, after time.Time) (time.Time, error) {
+	loc := common.LocalLocation()
+	if timeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timeZone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone %q", timeZone)
+		}
+	}
+
+	schedule, err := cronParser.Parse(spec)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron spec: %v", err)
+	}
+
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("cron spec never fires")
+	}
+	return next.UTC(), nil
+}
+
+// StartCron 启动周期任务调度器，ctx 取消时退出
+// 任务持久化在数据库中，停机期间错过的触发在重启后只补发一次
+func StartCron(ctx context.Context) {
+	go func() {
+		for {
+			wait := scheduleMaxWait
+			if next := processCron(); !next.IsZero() {
+				wait = min(max(time.Until(next), 0), scheduleMaxWait)
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-cronWake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// SaveCronJob 新增或更新周期任务
+func SaveCronJob(job *common.CronJob) error {
+	cronMu.Lock()
+	err := saveCronJob(job)
+	cronMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case cronWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// CronJobs 返回所有周期任务
+func CronJobs() ([]*common.CronJob, error) {
+	records, err := database.DB.Records(CronBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*common.CronJob, 0, len(records))
+	for _, record := range records {
+		job := &common.CronJob{}
+		if err = json.Unmarshal(record.Data, job); err != nil {
+			log.Println(fmt.Sprintf("failed to decode cron job %s: %v", record.ID, err))
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// CronJobByID 返回指定 id 的周期任务
+func CronJobByID(id string) (*common.CronJob, error) {
+	data, err := database.DB.RecordByID(CronBucket, id)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &common.CronJob{}
+	if err = json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// DeleteCronJob 删除周期任务
+func DeleteCronJob(id string) error {
+	cronMu.Lock()
+	defer cronMu.Unlock()
+
+	if _, err := database.DB.RecordByID(CronBucket, id); err != nil {
+		return err
+	}
+	return database.DB.DeleteRecord(CronBucket, id)
+}
+
+func saveCronJob(job *common.CronJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return database.DB.SaveRecord(CronBucket, job.ID, data)
+}
+
+// processCron 触发所有到期的任务，返回最近一次触发时间
+func processCron() time.Time {
+	cronMu.Lock()
+	defer cronMu.Unlock()
+
+	jobs, err := CronJobs()
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to load cron jobs: %v", err))
+		return time.Time{}
+	}
+
+	var next time.Time
+	now := common.DateNow()
+	for _, job := range jobs {
+		if job.Paused {
+			continue
+		}
+
+		if !job.NextRun.After(now) {
+			nextRun, err := CronNext(job.Spec, job.TimeZone, now)
+			if err != nil {
+				// 时区数据变化等原因导致任务失效，暂停任务而不是每次都重试
+				job.Paused = true
+				job.LastError = err.Error()
+				if err = saveCronJob(job); err != nil {
+					log.Println(fmt.Sprintf("failed to pause cron job %s: %v", job.ID, err))
+				}
+				continue
+			}
+
+			// 先保存下一次触发时间，避免发送期间被重复处理
+			job.LastRun = now
+			job.NextRun = nextRun
+			if err = saveCronJob(job); err != nil {
+				log.Println(fmt.Sprintf("failed to update cron job %s: %v", job.ID, err))
+				continue
+			}
+			go runCronJob(*job)
+		}
+
+		if next.IsZero() || job.NextRun.Before(next) {
+			next = job.NextRun
+		}
+	}
+	return next
+}
+
+func runCronJob(job common.CronJob) {
+	err := deliverCron(&job)
+	if err != nil {
+		log.Println(fmt.Sprintf("cron job %s failed: %v", job.ID, err))
+	}
+
+	cronMu.Lock()
+	defer cronMu.Unlock()
+
+	// 重新读取，期间任务可能已被修改或删除
+	current, loadErr := CronJobByID(job.ID)
+	if loadErr != nil {
+		return
+	}
+	current.LastError = ""
+	if err != nil {
+		current.LastError = err.Error()
+	}
+	if err = saveCronJob(current); err != nil {
+		log.Println(fmt.Sprintf("failed to update cron job %s: %v", job.ID, err))
+	}
+}
+
+// deliverCron 每次触发都重新解析参数，生成新的消息 id
+func deliverCron(job *common.CronJob) error {
+	params := common.NewParamsResultFromMap(job.Params)
+	if params == nil {
+		return errors.New("invalid push params")
+	}
+	if params.Err != nil {
+		return params.Err
+	}
+
+	ResolveTokens(params)
+	if len(params.Tokens) <= 0 {
+		return errors.New("no device token")
+	}
+
+	pushType := SelectPushType(params)
+	_, err := BatchPush(params, pushType)
+
+	// 管理员的任务与即时推送一样进入重试队列，直到 App 确认收到
+	if id, _ := params.Get(common.ID).(string); job.Admin && id != "" {
+		if qErr := Enqueue(id, params, pushType, err); qErr != nil {
+			log.Println(fmt.Sprintf("failed to enqueue cron push %s: %v", id, qErr))
+		}
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package push
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/apns2"
+)
+
+// ErrDispatchBusy 派发队列已满，调用方应稍后重试
+var ErrDispatchBusy = errors.New("push queue is full, try again later")
+
+// ErrBatchTooLarge 单次推送数量超过 max_batch_push_count
+var ErrBatchTooLarge = errors.New("too many pushes in one request")
+
+const (
+	// dispatchWait 队列已满时调用方最长等待时间
+	dispatchWait = 2 * time.Second
+	// workerIdle worker 空闲超过该时间后退出
+	workerIdle = 30 * time.Second
+)
+
+// pushJob 派发队列中的一次推送，done 在推送完成后调用
+type pushJob struct {
+	params   *common.ParamsMap
+	pushType apns2.EPushType
+	token    common.TokenInfo
+	done     func(*Response, error)
+}
+
+var (
+	dispatchOnce  sync.Once
+	dispatchQueue chan *pushJob
+	// workerSlots 限制同时运行的 worker 数量
+	workerSlots chan struct{}
+)
+
+// initDispatcher worker 数量由 system.workers 决定，队列长度由 system.queue_size 决定
+// worker 按需启动，空闲后退出，不会预先创建大量 goroutine
+func initDispatcher() {
+	dispatchQueue = make(chan *pushJob, max(common.LocalConfig.System.QueueSize, 1))
+	workerSlots = make(chan struct{}, max(common.LocalConfig.System.Workers, 1))
+}
+
+// dispatch 将推送放入派发队列，队列已满时最多等待 dispatchWait
+func dispatch(job *pushJob) error {
+	dispatchOnce.Do(initDispatcher)
+
+	select {
+	case dispatchQueue <- job:
+	default:
+		timer := time.NewTimer(dispatchWait)
+		defer timer.Stop()
+		select {
+		case dispatchQueue <- job:
+		case <-timer.C:
+			return ErrDispatchBusy
+		}
+	}
+
+	startWorker()
+	return nil
+}
+
+// startWorker 还有空闲名额时启动一个新的 worker
+func startWorker() {
+	select {
+	case workerSlots <- struct{}{}:
+		go worker()
+	default:
+	}
+}
+
+func worker() {
+	defer func() {
+		<-workerSlots
+		// 退出期间可能有新的推送进入队列，确保至少有一个 worker 处理
+		if len(dispatchQueue) > 0 {
+			startWorker()
+		}
+	}()
+
+	timer := time.NewTimer(workerIdle)
+	defer timer.Stop()
+	for {
+		select {
+		case job := <-dispatchQueue:
+			job.done(Push(job.params, job.pushType, job.token))
+			timer.Reset(workerIdle)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// DispatchStats 返回派发队列中等待的推送数量和运行中的 worker 数量
+func DispatchStats() (queued, workers int) {
+	dispatchOnce.Do(initDispatcher)
+	return len(dispatchQueue), len(workerSlots)
+}
@@ -0,0 +1,313 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/apns2"
+)
+
+const (
+	// fcmTimeout 请求 FCM 和获取 access token 的超时时间
+	fcmTimeout = 30 * time.Second
+	// fcmTokenRefreshMargin access token 过期前提前刷新的时间
+	fcmTokenRefreshMargin = 5 * time.Minute
+)
+
+// fcmProvider Android 设备的推送通道，使用 FCM HTTP v1 接口
+// access token 通过服务账号签名的 JWT 换取，过期前复用
+type fcmProvider struct {
+	account  *common.ServiceAccount
+	endpoint string
+	client   *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// fcmResponse messages:send 的响应，成功时返回 name，失败时返回 error
+type fcmResponse struct {
+	Name  string `json:"name"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type      string `json:"@type"`
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// CreateFCMClient 配置了服务账号时启用 Android 推送
+func CreateFCMClient() error {
+	conf := common.LocalConfig.FCM
+	if conf.Credentials == "" {
+		return nil
+	}
+
+	account, err := common.ReadServiceAccount(conf.Credentials)
+	if err != nil {
+		return err
+	}
+	projectID := conf.ProjectID
+	if projectID == "" {
+		projectID = account.ProjectID
+	}
+	if projectID == "" {
+		return errors.New("fcm project_id is not configured")
+	}
+	host := conf.Host
+	if host == "" {
+		host = common.FCMHost
+	}
+
+	RegisterProvider(&fcmProvider{
+		account:  account,
+		endpoint: fmt.Sprintf("%s/v1/projects/%s/messages:send", strings.TrimSuffix(host, "/"), url.PathEscape(projectID)),
+		client:   &http.Client{Timeout: fcmTimeout},
+	})
+	log.Println(fmt.Sprintf("FCM enabled for project %s", projectID))
+	return nil
+}
+
+func (p *fcmProvider) Name() string {
+	return common.PlatformAndroid
+}
+
+// Push 发送到 FCM，状态码不是 200 时同时返回 *FCMError
+func (p *fcmProvider) Push(params *common.ParamsMap, pushType apns2.EPushType, token common.TokenInfo) (*Response, error) {
+	if pushType == apns2.PushTypeLiveActivity {
+		return nil, errors.New("live activities are not supported on android")
+	}
+
+	delivery, err := common.ParseDelivery(params, pushType == apns2.PushTypeBackground)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery params: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]any{"message": fcmMessage(params, pushType, delivery, token.Token)})
+	if err != nil {
+		return nil, err
+	}
+
+	// access token 被撤销或已过期时重新获取后重试一次
+	var res *Response
+	for attempt := 0; ; attempt++ {
+		accessToken, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		if res, err = p.send(accessToken, body); err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusUnauthorized {
+			break
+		}
+		p.resetToken(accessToken)
+		if attempt > 0 {
+			break
+		}
+	}
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+
+	fcmErr := &FCMError{Token: token.Token, StatusCode: res.StatusCode, Reason: res.Reason}
+	if fcmErr.Class() == ReasonClassInvalidToken {
+		pruneToken(token.Token, res.Reason)
+	}
+	return res, fcmErr
+}
+
+// send 发送一条消息，请求到达 FCM 时总是返回 resp
+func (p *fcmProvider) send(accessToken string, body []byte) (*Response, error) {
+	req, err := http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set(common.HeaderContentType, common.MIMEApplicationJSON)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result fcmResponse
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+
+	res := &Response{StatusCode: resp.StatusCode, ID: result.Name}
+	if resp.StatusCode != http.StatusOK {
+		res.Reason = result.reason(resp.StatusCode)
+	}
+	return res, nil
+}
+
+// token 返回可用的 access token，快过期时重新获取
+func (p *fcmProvider) token() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.accessToken != "" && time.Now().Before(p.expiresAt) {
+		return p.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   p.account.ClientEmail,
+		"scope": common.FCMScope,
+		"aud":   p.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	assertion.Header["kid"] = p.account.PrivateKeyID
+	signed, err := assertion.SignedString(p.account.Key())
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.client.PostForm(p.account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signed},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get FCM access token: %s", errorReason(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("failed to get FCM access token: %d %s %s", resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+
+	p.accessToken = result.AccessToken
+	p.expiresAt = now.Add(time.Duration(result.ExpiresIn)*time.Second - fcmTokenRefreshMargin)
+	return p.accessToken, nil
+}
+
+// resetToken 丢弃被拒绝的 access token，其他推送已经刷新过时保留新的
+func (p *fcmProvider) resetToken(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.accessToken == token {
+		p.accessToken = ""
+	}
+}
+
+// reason 优先使用 FCM 的 errorCode，没有时使用 gRPC 状态
+func (r *fcmResponse) reason(statusCode int) string {
+	if r.Error != nil {
+		for _, detail := range r.Error.Details {
+			if detail.ErrorCode != "" {
+				return detail.ErrorCode
+			}
+		}
+		if r.Error.Status != "" {
+			return r.Error.Status
+		}
+	}
+	return http.StatusText(statusCode)
+}
+
+// fcmMessage 生成 FCM 消息，提醒推送带 notification，静默推送只有 data
+// 自定义参数放入 data，FCM 要求 data 的值都是字符串
+func fcmMessage(params *common.ParamsMap, pushType apns2.EPushType, delivery *common.Delivery, token string) map[string]any {
+	data := map[string]string{}
+	for pair := params.Oldest(); pair != nil; pair = pair.Next() {
+		if _, skip := skipKeys[pair.Key]; skip || fcmReservedKey(pair.Key) {
+			continue
+		}
+		data[pair.Key] = fcmDataValue(pair.Value)
+	}
+
+	android := map[string]any{}
+	if delivery.Priority == common.PriorityImmediate {
+		android["priority"] = "high"
+	} else if delivery.Priority > 0 {
+		android["priority"] = "normal"
+	}
+	if id := common.PMGet(params, common.ID); id != "" {
+		android["collapse_key"] = id
+	}
+	now := common.DateNow()
+	if expires := delivery.ExpiresAt(now); !expires.IsZero() {
+		android["ttl"] = fmt.Sprintf("%ds", int64(max(expires.Sub(now), 0).Seconds()))
+	}
+
+	message := map[string]any{"token": token, "android": android}
+	if len(data) > 0 {
+		message["data"] = data
+	}
+
+	if pushType != apns2.PushTypeBackground {
+		notification := map[string]string{}
+		if title := common.PMGet(params, common.Title); title != "" {
+			notification["title"] = title
+		}
+		if body := common.PMGet(params, common.Body); body != "" {
+			notification["body"] = body
+		}
+		message["notification"] = notification
+
+		androidNotification := map[string]any{
+			"notification_priority": notificationPriorities[delivery.Level],
+		}
+		if sound := common.PMGet(params, common.Sound); sound != "" {
+			androidNotification["sound"] = sound
+		}
+		if id := common.PMGet(params, common.ID); id != "" {
+			androidNotification["tag"] = id
+		}
+		android["notification"] = androidNotification
+	}
+	return message
+}
+
+// notificationPriorities level 参数对应的 Android 通知优先级
+var notificationPriorities = map[string]string{
+	common.LevelPassive:       "PRIORITY_LOW",
+	common.LevelActive:        "PRIORITY_DEFAULT",
+	common.LevelTimeSensitive: "PRIORITY_HIGH",
+	common.LevelCritical:      "PRIORITY_MAX",
+}
+
+// fcmReservedKey FCM 不允许在 data 中使用的键
+func fcmReservedKey(key string) bool {
+	switch key {
+	case "from", "notification", "message_type":
+		return true
+	}
+	return strings.HasPrefix(key, "google") || strings.HasPrefix(key, "gcm")
+}
+
+// fcmDataValue 字符串直接使用，其他类型转换为 JSON
+func fcmDataValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
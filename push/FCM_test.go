@@ -0,0 +1,144 @@
+package push
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/fakeapns"
+	"github.com/sunvc/apns2"
+)
+
+// startFakeFCM 使用新生成的服务账号启动 FCM 模拟接口，并让 FCM 客户端连接到该地址
+func startFakeFCM(t *testing.T) (*fakeapns.Server, *fcmProvider) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := fakeapns.NewServer(nil, 0)
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+
+	data, err := json.Marshal(&common.ServiceAccount{
+		Type:         "service_account",
+		ProjectID:    "nolet-test",
+		PrivateKeyID: "test-key",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ClientEmail:  "push@nolet-test.iam.gserviceaccount.com",
+		TokenURI:     httpServer.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := filepath.Join(t.TempDir(), "service-account.json")
+	if err = os.WriteFile(credentials, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := common.ReadServiceAccount(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.EnableFCM(account, "")
+
+	conf := common.LocalConfig.FCM
+	t.Cleanup(func() { common.LocalConfig.FCM = conf })
+	common.LocalConfig.FCM = common.FCM{Credentials: credentials, Host: httpServer.URL}
+	if err = CreateFCMClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := providerFor(common.PlatformAndroid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, provider.(*fcmProvider)
+}
+
+func TestFCMPush(t *testing.T) {
+	server, _ := startFakeFCM(t)
+	key, token := "fcmSendKey", "fcm-token-send"
+	params := registerTestDevice(t, key, common.TokenInfo{Token: token, Platform: common.PlatformAndroid})
+
+	report, err := BatchPush(params, apns2.PushTypeAlert)
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if report.Success != 1 || report.Results[0].MessageID == "" {
+		t.Fatalf("unexpected report: %+v", report.Results[0])
+	}
+
+	notifications := server.Notifications(token, 0)
+	if len(notifications) != 1 || notifications[0].Status != http.StatusOK {
+		t.Fatalf("expected 1 delivered notification, got %+v", notifications)
+	}
+}
+
+func TestFCMRefreshesRejectedAccessToken(t *testing.T) {
+	server, provider := startFakeFCM(t)
+	key, token := "fcmAuthKey", "fcm-token-auth"
+	params := registerTestDevice(t, key, common.TokenInfo{Token: token, Platform: common.PlatformAndroid})
+
+	// 模拟被撤销但还未过期的 access token
+	provider.mu.Lock()
+	provider.accessToken = "revoked-access-token"
+	provider.expiresAt = time.Now().Add(time.Hour)
+	provider.mu.Unlock()
+
+	report, err := BatchPush(params, apns2.PushTypeAlert)
+	if err != nil {
+		t.Fatalf("expected push to succeed after refreshing the access token: %v", err)
+	}
+	if report.Success != 1 {
+		t.Fatalf("unexpected report: %+v", report.Results[0])
+	}
+
+	provider.mu.Lock()
+	accessToken := provider.accessToken
+	provider.mu.Unlock()
+	if accessToken == "" || accessToken == "revoked-access-token" {
+		t.Fatalf("expected a new access token, got %q", accessToken)
+	}
+	if notifications := server.Notifications(token, 0); len(notifications) != 1 {
+		t.Fatalf("expected 1 delivered notification, got %d", len(notifications))
+	}
+}
+
+func TestFCMPrunesUnregisteredToken(t *testing.T) {
+	server, _ := startFakeFCM(t)
+	key, token := "fcmGoneKey", "fcm-token-gone"
+	if _, err := server.AddRule(fakeapns.Rule{Token: token, Reason: "UNREGISTERED"}); err != nil {
+		t.Fatal(err)
+	}
+	params := registerTestDevice(t, key, common.TokenInfo{Token: token, Platform: common.PlatformAndroid})
+
+	report, err := BatchPush(params, apns2.PushTypeAlert)
+	if err == nil {
+		t.Fatal("expected push to an unregistered token to fail")
+	}
+	if report.Failed != 1 || report.Results[0].Status != http.StatusNotFound || report.Results[0].Reason != "UNREGISTERED" {
+		t.Fatalf("unexpected report: %+v", report.Results[0])
+	}
+
+	tokens, _ := database.DB.DeviceTokensByKey(key)
+	if len(tokens) != 0 {
+		t.Fatalf("expected unregistered token to be pruned, got %+v", tokens)
+	}
+}
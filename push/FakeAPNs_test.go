@@ -0,0 +1,192 @@
+package push
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/NoLets/fakeapns"
+	"github.com/sunvc/apns2"
+)
+
+// fakeServer 测试使用的 APNs 模拟服务，TestMain 中启动
+var fakeServer *fakeapns.Server
+
+func TestMain(m *testing.M) {
+	dataDir, err := os.MkdirTemp("", "nolet-push-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	code := func() int {
+		defer func() { _ = os.RemoveAll(dataDir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err = setupFakeAPNs(ctx, dataDir); err != nil {
+			log.Println(fmt.Sprintf("failed to start fake apns: %v", err))
+			return 1
+		}
+		defer CloseAPNSClients()
+		defer func() { _ = database.DB.Close() }()
+		return m.Run()
+	}()
+	os.Exit(code)
+}
+
+// setupFakeAPNs 使用测试配置启动 fake-apns，并让 APNs 客户端连接到该地址
+func setupFakeAPNs(ctx context.Context, dataDir string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	common.LocalConfig.System.Name = "NoLetTest"
+	common.LocalConfig.System.MaxDeviceKeyArrLength = 10
+	common.LocalConfig.System.MaxRetryCount = 5
+	common.LocalConfig.System.RetryInterval = time.Minute
+	common.LocalConfig.Apple = common.Apple{
+		ApnsPrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		Topic:          "me.uuneo.Meoworld.test",
+		KeyID:          "TESTKEY123",
+		TeamID:         "TESTTEAM12",
+		Host:           "http://" + addr,
+	}
+
+	database.DB = database.NewBboltdb(dataDir)
+
+	fakeServer = fakeapns.NewServer(common.AppleApps(), 0)
+	go func() {
+		if err := fakeapns.Run(ctx, fakeServer, addr, "", ""); err != nil {
+			log.Println(fmt.Sprintf("fake apns stopped: %v", err))
+		}
+	}()
+
+	// 等待模拟服务开始监听
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			break
+		}
+		if i >= 50 {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	CreateAPNSClient(1)
+	return nil
+}
+
+// testDeviceToken 生成 fake-apns 接受的 64 位十六进制 token
+func testDeviceToken(name string) string {
+	return fmt.Sprintf("%x", name) + strings.Repeat("0", 64-len(name)*2)
+}
+
+// registerTestDevice 在 key 下注册一个 token，返回推送该 key 的参数
+func registerTestDevice(t *testing.T, key string, token common.TokenInfo) *common.ParamsResult {
+	t.Helper()
+	if _, err := database.DB.SaveDeviceTokenByKey(key, token); err != nil {
+		t.Fatalf("failed to register device: %v", err)
+	}
+
+	params := common.NewParamsResultFromMap(map[string]interface{}{
+		common.DeviceKey: key,
+		"title":          "test",
+		"body":           "hello",
+	})
+	if params == nil || params.Err != nil {
+		t.Fatalf("invalid push params: %+v", params)
+	}
+	ResolveTokens(params)
+	if len(params.Tokens) != 1 {
+		t.Fatalf("expected 1 resolved token, got %d", len(params.Tokens))
+	}
+	return params
+}
+
+func TestBatchPushPrunesUnregisteredToken(t *testing.T) {
+	key, token := "apnsGoneKey", testDeviceToken("gone")
+	if _, err := fakeServer.AddRule(fakeapns.Rule{Token: token, Reason: apns2.ReasonUnregistered}); err != nil {
+		t.Fatal(err)
+	}
+	params := registerTestDevice(t, key, common.TokenInfo{Token: token})
+
+	report, err := BatchPush(params, apns2.PushTypeAlert)
+	if err == nil {
+		t.Fatal("expected push to an unregistered token to fail")
+	}
+	if report.Failed != 1 || report.Results[0].Status != 410 || report.Results[0].Reason != apns2.ReasonUnregistered {
+		t.Fatalf("unexpected report: %+v", report.Results[0])
+	}
+
+	tokens, _ := database.DB.DeviceTokensByKey(key)
+	if len(tokens) != 0 {
+		t.Fatalf("expected unregistered token to be pruned, got %+v", tokens)
+	}
+}
+
+func TestRetryQueueKeepsThrottledPush(t *testing.T) {
+	key, token := "apnsBusyKey", testDeviceToken("busy")
+	if _, err := fakeServer.AddRule(fakeapns.Rule{Token: token, Reason: apns2.ReasonTooManyRequests}); err != nil {
+		t.Fatal(err)
+	}
+	params := registerTestDevice(t, key, common.TokenInfo{Token: token})
+
+	id := "throttled-message"
+	if err := Enqueue(id, params, apns2.PushTypeAlert, nil); err != nil {
+		t.Fatal(err)
+	}
+	item, err := queueItem(QueueBucket, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retryItem(item)
+
+	item, err = queueItem(QueueBucket, id)
+	if err != nil {
+		t.Fatalf("expected throttled push to stay in the retry queue: %v", err)
+	}
+	if item.State != QueueStatePending || item.Count != 2 {
+		t.Fatalf("unexpected queue item: state=%s count=%d", item.State, item.Count)
+	}
+	if !strings.Contains(item.LastError, apns2.ReasonTooManyRequests) {
+		t.Fatalf("expected last error to be %s, got %q", apns2.ReasonTooManyRequests, item.LastError)
+	}
+	if !item.NextPushDate.After(item.LastPushDate) {
+		t.Fatalf("expected next push after %v, got %v", item.LastPushDate, item.NextPushDate)
+	}
+	if _, err = queueItem(DeadBucket, id); err == nil {
+		t.Fatal("throttled push should not be moved to the dead-letter queue")
+	}
+
+	tokens, _ := database.DB.DeviceTokensByKey(key)
+	if len(tokens) != 1 {
+		t.Fatalf("throttled token should not be pruned, got %+v", tokens)
+	}
+}
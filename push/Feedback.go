@@ -0,0 +1,153 @@
+package push
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/apns2"
+)
+
+// ReasonClass APNs 返回原因的分类
+type ReasonClass int
+
+const (
+	ReasonClassSuccess      ReasonClass = iota // 推送成功
+	ReasonClassInvalidToken                    // token 已失效，需要从数据库中清理
+	ReasonClassRetryable                       // 服务端临时错误，可以稍后重试
+	ReasonClassRejected                        // 请求本身有误，重试也不会成功
+)
+
+// prunedTokens 自启动以来清理的失效 token 数量
+var prunedTokens atomic.Int64
+
+// APNsError APNs 拒绝推送时返回的错误
+type APNsError struct {
+	Token      string
+	StatusCode int
+	Reason     string
+}
+
+func (e *APNsError) Error() string {
+	return fmt.Sprintf("APNs push failed: %s", e.Reason)
+}
+
+// Class 返回错误原因的分类
+func (e *APNsError) Class() ReasonClass {
+	return ClassifyReason(e.StatusCode, e.Reason)
+}
+
+// ClassifyReason 根据 APNs 返回的状态码和原因对结果进行分类
+func ClassifyReason(statusCode int, reason string) ReasonClass {
+	if statusCode == http.StatusOK {
+		return ReasonClassSuccess
+	}
+
+	switch reason {
+	case apns2.ReasonUnregistered,
+		apns2.ReasonBadDeviceToken,
+		apns2.ReasonDeviceTokenNotForTopic,
+		apns2.ReasonExpiredToken:
+		return ReasonClassInvalidToken
+	case apns2.ReasonTooManyRequests,
+		apns2.ReasonInternalServerError,
+		apns2.ReasonServiceUnavailable,
+		apns2.ReasonShutdown,
+		apns2.ReasonIdleTimeout,
+		apns2.ReasonExpiredProviderToken:
+		return ReasonClassRetryable
+	}
+
+	if statusCode == http.StatusGone {
+		return ReasonClassInvalidToken
+	}
+	if statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError {
+		return ReasonClassRetryable
+	}
+	return ReasonClassRejected
+}
+
+// FCMError FCM 拒绝推送时返回的错误
+type FCMError struct {
+	Token      string
+	StatusCode int
+	Reason     string
+}
+
+func (e *FCMError) Error() string {
+	return fmt.Sprintf("FCM push failed: %s", e.Reason)
+}
+
+// Class 返回错误原因的分类
+func (e *FCMError) Class() ReasonClass {
+	return ClassifyFCMReason(e.StatusCode, e.Reason)
+}
+
+// ClassifyFCMReason 根据 FCM 返回的状态码和 errorCode 对结果进行分类
+func ClassifyFCMReason(statusCode int, reason string) ReasonClass {
+	if statusCode == http.StatusOK {
+		return ReasonClassSuccess
+	}
+
+	switch reason {
+	case "UNREGISTERED", "SENDER_ID_MISMATCH":
+		return ReasonClassInvalidToken
+	case "QUOTA_EXCEEDED", "UNAVAILABLE", "INTERNAL", "UNAUTHENTICATED":
+		return ReasonClassRetryable
+	}
+
+	if statusCode == http.StatusNotFound {
+		return ReasonClassInvalidToken
+	}
+	if statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError {
+		return ReasonClassRetryable
+	}
+	return ReasonClassRejected
+}
+
+// WebPushError 浏览器推送服务拒绝推送时返回的错误
+type WebPushError struct {
+	Token      string
+	StatusCode int
+	Reason     string
+}
+
+func (e *WebPushError) Error() string {
+	return fmt.Sprintf("Web Push failed: %d %s", e.StatusCode, e.Reason)
+}
+
+// Class 推送服务只返回状态码，404 / 410 表示订阅已失效
+func (e *WebPushError) Class() ReasonClass {
+	switch {
+	case e.StatusCode >= 200 && e.StatusCode < 300:
+		return ReasonClassSuccess
+	case e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone:
+		return ReasonClassInvalidToken
+	case e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError:
+		return ReasonClassRetryable
+	}
+	return ReasonClassRejected
+}
+
+// pruneToken 从数据库中移除推送通道报告为失效的 token
+func pruneToken(token, reason string) {
+	if database.DB == nil || token == "" {
+		return
+	}
+	count, err := database.DB.DeleteDeviceToken(token)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to prune device token (%s): %v", reason, err))
+		return
+	}
+	if count > 0 {
+		prunedTokens.Add(int64(count))
+		log.Println(fmt.Sprintf("pruned %d device key(s) holding an invalid token: %s", count, reason))
+	}
+}
+
+// PrunedTokenCount 返回自启动以来清理的失效 token 数量
+func PrunedTokenCount() int64 {
+	return prunedTokens.Load()
+}
@@ -0,0 +1,115 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+)
+
+// IdempotencyBucket 幂等推送结果保存的位置
+const IdempotencyBucket = "idempotency"
+
+// idempotencyCleanInterval 清理过期幂等记录的间隔
+const idempotencyCleanInterval = time.Hour
+
+// ErrIdempotencyInProgress 相同幂等 key 的请求正在处理
+var ErrIdempotencyInProgress = errors.New("a request with the same idempotency key is in progress")
+
+var (
+	idempotencyMu      sync.Mutex
+	idempotencyPending = make(map[string]struct{})
+)
+
+// StartIdempotencyCleaner 定期删除过期的幂等记录，ctx 取消时退出
+func StartIdempotencyCleaner(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(idempotencyCleanInterval)
+		defer ticker.Stop()
+		for {
+			cleanIdempotency()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// BeginIdempotent 返回窗口期内已经保存的结果，没有结果时把 id 标记为处理中
+// 返回 nil 时调用方需要在处理完成后调用 EndIdempotent
+func BeginIdempotent(id string) (*common.IdempotentResult, error) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+
+	if _, ok := idempotencyPending[id]; ok {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	data, err := database.DB.RecordByID(IdempotencyBucket, id)
+	if err == nil {
+		result := &common.IdempotentResult{}
+		if err = json.Unmarshal(data, result); err == nil && result.ExpiresAt.After(common.DateNow()) {
+			return result, nil
+		}
+	} else if !errors.Is(err, database.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	idempotencyPending[id] = struct{}{}
+	return nil, nil
+}
+
+// SaveIdempotent 保存请求的结果，窗口期内相同幂等 key 的请求直接返回该结果
+func SaveIdempotent(id string, response any) error {
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	now := common.DateNow()
+	data, err := json.Marshal(&common.IdempotentResult{
+		ID:         id,
+		Response:   raw,
+		CreateDate: now,
+		ExpiresAt:  now.Add(common.LocalConfig.System.IdempotencyWindow),
+	})
+	if err != nil {
+		return err
+	}
+	return database.DB.SaveRecord(IdempotencyBucket, id, data)
+}
+
+// EndIdempotent 结束处理，之后相同幂等 key 的请求可以读取保存的结果或重新推送
+func EndIdempotent(id string) {
+	idempotencyMu.Lock()
+	delete(idempotencyPending, id)
+	idempotencyMu.Unlock()
+}
+
+// cleanIdempotency 删除过期的幂等记录
+func cleanIdempotency() {
+	records, err := database.DB.Records(IdempotencyBucket)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to load idempotency records: %v", err))
+		return
+	}
+
+	now := common.DateNow()
+	for _, record := range records {
+		result := &common.IdempotentResult{}
+		if err = json.Unmarshal(record.Data, result); err == nil && result.ExpiresAt.After(now) {
+			continue
+		}
+		if err = database.DB.DeleteRecord(IdempotencyBucket, record.ID); err != nil {
+			log.Println(fmt.Sprintf("failed to delete idempotency record %s: %v", record.ID, err))
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/apns2/payload"
+)
+
+// LiveActivityBucket 实时活动 token 保存的位置，记录 id 为设备 key
+const LiveActivityBucket = "liveactivity"
+
+// LiveActivityTopicSuffix 实时活动推送的 Topic 后缀
+const LiveActivityTopicSuffix = ".push-type.liveactivity"
+
+// MaxLiveActivityTokens 每个 key 最多保存的实时活动 token 数量，超出时淘汰最久未更新的
+const MaxLiveActivityTokens = 20
+
+// liveActivityMu 串行化实时活动 token 的读写
+var liveActivityMu sync.Mutex
+
+// LiveActivityTokens 返回 key 下注册的所有实时活动 token
+func LiveActivityTokens(key string) ([]common.LiveActivityToken, error) {
+	data, err := database.DB.RecordByID(LiveActivityBucket, key)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		return []common.LiveActivityToken{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []common.LiveActivityToken
+	if err = json.Unmarshal(data, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// SaveLiveActivityToken 注册或更新实时活动 token
+func SaveLiveActivityToken(key string, token common.LiveActivityToken) error {
+	liveActivityMu.Lock()
+	defer liveActivityMu.Unlock()
+
+	tokens, err := LiveActivityTokens(key)
+	if err != nil {
+		return err
+	}
+
+	now := common.DateNow()
+	token.CreatedAt = now
+	token.UpdatedAt = now
+
+	result := make([]common.LiveActivityToken, 0, len(tokens)+1)
+	for _, t := range tokens {
+		if t.Token == token.Token {
+			token.CreatedAt = t.CreatedAt
+			continue
+		}
+		result = append(result, t)
+	}
+	result = append(result, token)
+
+	// result 按注册顺序排列，最后更新的在末尾
+	if over := len(result) - MaxLiveActivityTokens; over > 0 {
+		result = result[over:]
+	}
+	return saveLiveActivityTokens(key, result)
+}
+
+// RemoveLiveActivityToken 移除 key 下的实时活动 token，token 为空时移除全部
+func RemoveLiveActivityToken(key, token string) error {
+	liveActivityMu.Lock()
+	defer liveActivityMu.Unlock()
+
+	if token == "" {
+		return database.DB.DeleteRecord(LiveActivityBucket, key)
+	}
+
+	tokens, err := LiveActivityTokens(key)
+	if err != nil {
+		return err
+	}
+
+	result := make([]common.LiveActivityToken, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Token != token {
+			result = append(result, t)
+		}
+	}
+	if len(result) == len(tokens) {
+		return fmt.Errorf("live activity token not found in key [%s]", key)
+	}
+	return saveLiveActivityTokens(key, result)
+}
+
+func saveLiveActivityTokens(key string, tokens []common.LiveActivityToken) error {
+	if len(tokens) == 0 {
+		return database.DB.DeleteRecord(LiveActivityBucket, key)
+	}
+
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return database.DB.SaveRecord(LiveActivityBucket, key, data)
+}
+
+// resolveLiveActivityTokens 按事件选择 token
+// start 使用 push-to-start token，update / end 使用已启动活动的 token
+func resolveLiveActivityTokens(params *common.ParamsResult) {
+	la := params.LiveActivity
+	for _, key := range params.Keys {
+		tokens, err := LiveActivityTokens(key)
+		if err != nil {
+			log.Println(fmt.Sprintf("failed to load live activity tokens of [%s]: %v", key, err))
+			continue
+		}
+
+		for _, token := range tokens {
+			if la.Event == common.LiveActivityStart {
+				if token.Type != common.LiveActivityStart ||
+					(token.AttributesType != "" && token.AttributesType != la.AttributesType) {
+					continue
+				}
+			} else {
+				if token.Type != common.LiveActivityUpdate ||
+					(la.ActivityID != "" && token.ActivityID != la.ActivityID) {
+					continue
+				}
+			}
+
+			info := token.TokenInfo
+			info.Key = key
+			params.Tokens = append(params.Tokens, info)
+		}
+	}
+}
+
+// liveActivityPayload 生成实时活动的 aps 内容，标题和内容作为可选的提醒
+func liveActivityPayload(params *common.ParamsMap, la *common.LiveActivity) *payload.Payload {
+	pl := payload.NewPayload().
+		SetEvent(payload.ELiveActivityEvent(la.Event)).
+		SetTimestamp(common.DateNow().Unix())
+
+	if la.ContentState != nil {
+		pl.SetContentState(la.ContentState)
+	}
+	if la.Event == common.LiveActivityStart {
+		pl.SetAttributesType(la.AttributesType).SetAttributes(la.Attributes)
+	}
+	if !la.StaleDate.IsZero() {
+		pl.SetStaleDate(la.StaleDate.Unix())
+	}
+	if !la.DismissalDate.IsZero() {
+		pl.SetDismissalDate(la.DismissalDate.Unix())
+	}
+
+	title, body := common.PMGet(params, common.Title), common.PMGet(params, common.Body)
+	if title != "" || body != "" {
+		pl.AlertTitle(title).AlertBody(body)
+		if sound := common.PMGet(params, common.Sound); sound != "" {
+			pl.Sound(sound)
+		}
+	}
+	return pl
+}
+
+// removeLiveActivityToken 移除已经失效的实时活动 token，直接指定 token 推送时没有 key，不做处理
+func removeLiveActivityToken(token common.TokenInfo) {
+	if token.Key == "" || database.DB == nil {
+		return
+	}
+	if err := RemoveLiveActivityToken(token.Key, token.Token); err != nil {
+		log.Println(fmt.Sprintf("failed to remove live activity token: %v", err))
+	}
+}
@@ -0,0 +1,123 @@
+package push
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+)
+
+// MessageBucket 长消息保存的位置
+const MessageBucket = "messages"
+
+// messageCleanInterval 清理过期长消息的间隔
+const messageCleanInterval = time.Hour
+
+// ErrMessageNotFound 长消息不存在或已过期
+var ErrMessageNotFound = errors.New("message not found")
+
+// StartMessageCleaner 定期删除过期的长消息，ctx 取消时退出
+func StartMessageCleaner(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(messageCleanInterval)
+		defer ticker.Stop()
+		for {
+			cleanMessages()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// MessageByID 返回未过期的长消息
+func MessageByID(id string) (*common.StoredMessage, error) {
+	data, err := database.DB.RecordByID(MessageBucket, id)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &common.StoredMessage{}
+	if err = json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	if !msg.ExpiresAt.After(common.DateNow()) {
+		_ = database.DB.DeleteRecord(MessageBucket, id)
+		return nil, ErrMessageNotFound
+	}
+	return msg, nil
+}
+
+// storeLongMessage 保存超出长度的完整内容，把拆分后的多段推送替换为一条预览
+// 替换后 params.Results 为空，重试时不会重复保存
+func storeLongMessage(params *common.ParamsResult) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+
+	now := common.DateNow()
+	expiresAt := now.Add(common.LocalConfig.System.MessageRetention)
+	// 消息在 APNs 中保存的时间更长时，保证设备收到预览时仍然可以获取
+	if delivery, err := common.ParseDelivery(params.Params, false); err == nil {
+		if pushExpires := delivery.ExpiresAt(now); pushExpires.After(expiresAt) {
+			expiresAt = pushExpires
+		}
+	}
+
+	msg := &common.StoredMessage{
+		ID:         shortuuid.New(),
+		Token:      hex.EncodeToString(token),
+		Title:      common.PMGet(params.Params, common.Title),
+		Subtitle:   common.PMGet(params.Params, common.Subtitle),
+		Body:       common.PMGet(params.Params, common.Body),
+		Keys:       params.Keys,
+		CreateDate: now,
+		ExpiresAt:  expiresAt,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err = database.DB.SaveRecord(MessageBucket, msg.ID, data); err != nil {
+		return err
+	}
+
+	first := common.PMGet(params.Results[0], common.Body)
+	params.Params = common.MessagePreview(params.Params, first, msg)
+	params.Results = []*common.ParamsMap{}
+	return nil
+}
+
+// cleanMessages 删除过期的长消息
+func cleanMessages() {
+	records, err := database.DB.Records(MessageBucket)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to load messages: %v", err))
+		return
+	}
+
+	now := common.DateNow()
+	for _, record := range records {
+		msg := &common.StoredMessage{}
+		if err = json.Unmarshal(record.Data, msg); err == nil && msg.ExpiresAt.After(now) {
+			continue
+		}
+		if err = database.DB.DeleteRecord(MessageBucket, record.ID); err != nil {
+			log.Println(fmt.Sprintf("failed to delete message %s: %v", record.ID, err))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package push
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/apns2"
+)
+
+// Response 推送通道对单个 token 的响应
+type Response struct {
+	StatusCode int
+	ID         string    // APNs 返回的 apns-id、FCM 返回的消息名称或 Web Push 返回的 Location
+	Reason     string    // 失败原因，APNs 的 reason 或 FCM 的 errorCode
+	Timestamp  time.Time // token 最后一次确认失效的时间，只有 APNs 返回 410 时有值
+}
+
+// Provider 一个平台的推送通道
+// 收到响应时总是返回 resp，推送被拒绝时同时返回可分类的错误 (*APNsError / *FCMError / *WebPushError)
+type Provider interface {
+	// Name 通道对应的设备平台
+	Name() string
+	Push(params *common.ParamsMap, pushType apns2.EPushType, token common.TokenInfo) (*Response, error)
+}
+
+var (
+	providers   = map[string]Provider{}
+	providersMu sync.RWMutex
+)
+
+// RegisterProvider 注册推送通道，同一平台重复注册时替换
+func RegisterProvider(provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[provider.Name()] = provider
+}
+
+// Providers 返回已启用的设备平台
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// providerFor 返回设备平台对应的推送通道
+func providerFor(platform string) (Provider, error) {
+	name, ok := common.NormalizePlatform(platform)
+	if !ok {
+		return nil, fmt.Errorf("unknown platform %q", platform)
+	}
+
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("push provider %s is not configured", name)
+	}
+	return provider, nil
+}
+
+// Push 按 token 注册时的平台选择推送通道发送
+func Push(params *common.ParamsMap, pushType apns2.EPushType, token common.TokenInfo) (*Response, error) {
+	provider, err := providerFor(token.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	// 服务端加密时每台设备使用自己的公钥，推送通道只能看到密文
+	// Web Push 的内容已经按 RFC 8291 加密给浏览器，不需要再加密
+	if common.Encrypted(params) && pushType == apns2.PushTypeAlert && provider.Name() != common.PlatformWeb {
+		if params, err = common.SealParams(params, token.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+	return provider.Push(params, pushType, token)
+}
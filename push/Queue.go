@@ -0,0 +1,270 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+	"github.com/sunvc/apns2"
+)
+
+// 持久化的重试队列
+// 管理员推送的带 id 消息会按指数退避重复推送，直到 App 回调确认或达到最大次数后进入死信队列
+
+const (
+	QueueBucket = "queue" // 等待重试的消息
+	DeadBucket  = "dead"  // 超过最大次数的死信消息
+
+	QueueStatePending = "pending"
+	QueueStateDead    = "dead"
+
+	// queuePollInterval 检查到期消息的间隔
+	queuePollInterval = 15 * time.Second
+	// maxRetryBackoff 单次退避的上限
+	maxRetryBackoff = 24 * time.Hour
+)
+
+// queueMu 保证同一时间只有一处在修改队列记录
+var queueMu sync.Mutex
+
+// StartQueue 启动重试队列，ctx 取消时退出
+func StartQueue(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(queuePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				processQueue()
+			}
+		}
+	}()
+}
+
+// Enqueue 将消息加入重试队列，已存在时更新推送参数并累加次数
+func Enqueue(id string, params *common.ParamsResult, pushType apns2.EPushType, pushErr error) error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	now := common.DateNow()
+	item, err := queueItem(QueueBucket, id)
+	if err != nil {
+		item = &common.NotPushedData{ID: id, CreateDate: now}
+	}
+	item.Params = params
+	item.PushType = pushType
+
+	return recordAttempt(item, now, pushErr)
+}
+
+// Ack App 确认收到消息后从队列中移除
+func Ack(id string) error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	return database.DB.DeleteRecord(QueueBucket, id)
+}
+
+// QueueItems 返回指定队列中的所有消息
+func QueueItems(bucket string) ([]*common.NotPushedData, error) {
+	records, err := database.DB.Records(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*common.NotPushedData, 0, len(records))
+	for _, record := range records {
+		item := &common.NotPushedData{}
+		if err = json.Unmarshal(record.Data, item); err != nil {
+			log.Println(fmt.Sprintf("failed to decode queue item %s: %v", record.ID, err))
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// RetryDead 将死信消息重新放回重试队列，立即开始推送
+func RetryDead(id string) error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	item, err := queueItem(DeadBucket, id)
+	if err != nil {
+		return err
+	}
+	item.State = QueueStatePending
+	item.Count = 0
+	item.LastError = ""
+	item.NextPushDate = common.DateNow()
+
+	if err = saveQueueItem(QueueBucket, item); err != nil {
+		return err
+	}
+	return database.DB.DeleteRecord(DeadBucket, id)
+}
+
+// DeleteQueueItem 从指定队列中删除消息
+func DeleteQueueItem(bucket, id string) error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	if _, err := queueItem(bucket, id); err != nil {
+		return err
+	}
+	return database.DB.DeleteRecord(bucket, id)
+}
+
+func processQueue() {
+	items, err := QueueItems(QueueBucket)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to load retry queue: %v", err))
+		return
+	}
+
+	now := common.DateNow()
+	for _, item := range items {
+		if item.NextPushDate.After(now) {
+			continue // 还没到下一次推送时间，跳过
+		}
+		retryItem(item)
+	}
+}
+
+func retryItem(item *common.NotPushedData) {
+	if item.Params == nil {
+		queueMu.Lock()
+		defer queueMu.Unlock()
+		moveToDead(item, "invalid queue item without params")
+		return
+	}
+
+	// 每次重试前重新查询 key 下的 token，跳过已经失效或被删除的设备
+	refreshTokens(item.Params)
+	if len(item.Params.Tokens) <= 0 {
+		queueMu.Lock()
+		defer queueMu.Unlock()
+		if _, err := queueItem(QueueBucket, item.ID); err == nil {
+			if err = moveToDead(item, "no registered device tokens"); err != nil {
+				log.Println(fmt.Sprintf("failed to update queue item %s: %v", item.ID, err))
+			}
+		}
+		return
+	}
+
+	_, pushErr := BatchPush(item.Params, item.PushType)
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	// 推送期间 App 已经确认，不再写回队列
+	current, err := queueItem(QueueBucket, item.ID)
+	if err != nil {
+		return
+	}
+	current.Params = item.Params
+	current.PushType = item.PushType
+
+	if err = recordAttempt(current, common.DateNow(), pushErr); err != nil {
+		log.Println(fmt.Sprintf("failed to update queue item %s: %v", item.ID, err))
+	}
+}
+
+// refreshTokens 按设备 key 重新查询 token，直接指定的 token 保持不变
+func refreshTokens(params *common.ParamsResult) {
+	if len(params.Keys) <= 0 {
+		return
+	}
+
+	var direct []common.TokenInfo
+	for _, token := range params.Tokens {
+		if token.Key == "" {
+			direct = append(direct, token)
+		}
+	}
+	params.Tokens = nil
+	ResolveTokens(params)
+	params.Tokens = uniqueTokens(append(direct, params.Tokens...))
+}
+
+// recordAttempt 记录一次推送结果，并计算下一次推送时间或移入死信队列
+func recordAttempt(item *common.NotPushedData, now time.Time, pushErr error) error {
+	item.Count++
+	item.LastPushDate = now
+	item.LastError = ""
+	if pushErr != nil {
+		item.LastError = pushErr.Error()
+	}
+
+	if item.Count >= max(common.LocalConfig.System.MaxRetryCount, 1) {
+		reason := item.LastError
+		if reason == "" {
+			reason = "max attempts reached without acknowledgement"
+		}
+		return moveToDead(item, reason)
+	}
+
+	item.State = QueueStatePending
+	item.NextPushDate = now.Add(retryBackoff(item.Count))
+	return saveQueueItem(QueueBucket, item)
+}
+
+// retryBackoff 指数退避并加入随机抖动，避免大量消息同时重试
+func retryBackoff(attempt int) time.Duration {
+	base := common.LocalConfig.System.RetryInterval
+	if base <= 0 {
+		base = 10 * time.Minute
+	}
+
+	backoff := base
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxRetryBackoff)
+
+	half := backoff / 2
+	return half + rand.N(half+1)
+}
+
+func moveToDead(item *common.NotPushedData, reason string) error {
+	item.State = QueueStateDead
+	item.LastError = reason
+	item.NextPushDate = time.Time{}
+
+	if err := saveQueueItem(DeadBucket, item); err != nil {
+		return err
+	}
+	log.Println(fmt.Sprintf("message %s moved to dead-letter queue: %s", item.ID, reason))
+	return database.DB.DeleteRecord(QueueBucket, item.ID)
+}
+
+func queueItem(bucket, id string) (*common.NotPushedData, error) {
+	data, err := database.DB.RecordByID(bucket, id)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &common.NotPushedData{}
+	if err = json.Unmarshal(data, item); err != nil {
+		return nil, errors.Join(database.ErrRecordNotFound, err)
+	}
+	return item, nil
+}
+
+func saveQueueItem(bucket string, item *common.NotPushedData) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return database.DB.SaveRecord(bucket, item.ID, data)
+}
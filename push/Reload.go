@@ -0,0 +1,127 @@
+package push
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+)
+
+// credentialCheckInterval 检查推送凭证是否变化的间隔
+const credentialCheckInterval = 30 * time.Second
+
+// StartCredentialWatcher 定期检查推送凭证，私钥、证书或配置文件中的 App 配置变化时重建对应的连接池
+// 收到 SIGHUP 时立即检查
+func StartCredentialWatcher(ctx context.Context, configPath string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(credentialCheckInterval)
+		defer ticker.Stop()
+
+		// 相同的错误只记录一次，例如配置文件不存在时不会每次检查都输出
+		var lastErr string
+		for {
+			manual := false
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-hup:
+				manual = true
+				log.Println("received SIGHUP, reloading APNs credentials")
+			}
+
+			err := ReloadCredentials(configPath)
+			if err == nil {
+				lastErr = ""
+				continue
+			}
+			if manual || err.Error() != lastErr {
+				log.Println(fmt.Sprintf("failed to reload APNs credentials: %v", err))
+			}
+			lastErr = err.Error()
+		}
+	}()
+}
+
+// ReloadCredentials 重新读取 App 配置，凭证变化的 App 使用新的连接池，已删除的 App 关闭连接池
+// 旧连接池上正在进行的推送完成后关闭
+func ReloadCredentials(configPath string) error {
+	apps := common.AppleApps()
+	if configPath != "" {
+		var err error
+		if apps, err = common.ReadAppleApps(configPath, apps[0]); err != nil {
+			return err
+		}
+	}
+
+	clientsMu.RLock()
+	changed := map[string]string{}
+	keep := map[string]bool{}
+	for _, app := range apps {
+		keep[app.ID()] = true
+		if fingerprint := credentialFingerprint(app); fingerprint != credentialFingerprints[app.ID()] {
+			changed[app.ID()] = fingerprint
+		}
+	}
+	var removed []string
+	for id := range CLIENTS {
+		if !keep[id] {
+			removed = append(removed, id)
+		}
+	}
+	clientsMu.RUnlock()
+
+	// 先创建新的连接池再更新 App 配置，推送时总能找到对应的连接池
+	for _, app := range apps {
+		if fingerprint, ok := changed[app.ID()]; ok {
+			setAppPools(app.ID(), createAppClients(app), fingerprint)
+			log.Println(fmt.Sprintf("reloaded APNs credentials of %s", app.ID()))
+		}
+	}
+	// 配置没有变化时不替换，避免每次检查都更新 App 配置
+	if configPath != "" && !slices.Equal(apps, common.AppleApps()) {
+		common.SetAppleApps(apps)
+	}
+
+	for _, id := range removed {
+		clientsMu.Lock()
+		pools := CLIENTS[id]
+		delete(CLIENTS, id)
+		delete(credentialFingerprints, id)
+		delete(apnsCertExpiry, id)
+		clientsMu.Unlock()
+
+		for _, pool := range pools {
+			go pool.Close()
+		}
+		log.Println(fmt.Sprintf("removed APNs clients of %s", id))
+	}
+	return nil
+}
+
+// credentialFingerprint 计算 App 推送凭证的指纹，包含私钥和证书文件的内容
+func credentialFingerprint(app common.Apple) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00", app.Topic, app.KeyID, app.TeamID, app.Host, app.CertFile, app.CertPassword)
+	if key, err := app.PrivateKey(); err == nil {
+		h.Write(key)
+	}
+	if app.CertFile != "" {
+		if cert, err := os.ReadFile(app.CertFile); err == nil {
+			h.Write(cert)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
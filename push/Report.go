@@ -0,0 +1,87 @@
+package push
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+)
+
+// DeliveryResult 单个 token 的推送结果
+type DeliveryResult struct {
+	Key       string    `json:"key,omitempty"`
+	Token     string    `json:"token"`          // 脱敏后的 token
+	Part      int       `json:"part,omitempty"` // 内容过长被拆分时的序号，从 1 开始
+	Platform  string    `json:"platform,omitempty"`
+	Status    int       `json:"status"` // 推送通道返回的 HTTP 状态码，请求未到达时为 0
+	ApnsID    string    `json:"apnsId,omitempty"`
+	MessageID string    `json:"messageId,omitempty"` // FCM 和 Web Push 返回的消息 ID
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PushReport 一次批量推送的汇总结果
+type PushReport struct {
+	Total   int               `json:"total"`
+	Success int               `json:"success"`
+	Failed  int               `json:"failed"`
+	Results []*DeliveryResult `json:"results"`
+}
+
+// add 记录一次推送结果
+func (r *PushReport) add(result *DeliveryResult) {
+	r.Total++
+	if result.Status == 200 {
+		r.Success++
+	} else {
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// newDeliveryResult 根据推送通道的响应或请求错误生成推送结果
+func newDeliveryResult(token common.TokenInfo, part int, resp *Response, err error) *DeliveryResult {
+	platform, _ := common.NormalizePlatform(token.Platform)
+	result := &DeliveryResult{
+		Key:       token.Key,
+		Token:     MaskToken(token.Token),
+		Part:      part,
+		Platform:  platform,
+		Timestamp: common.DateNow(),
+	}
+
+	if resp != nil {
+		result.Status = resp.StatusCode
+		if platform == common.PlatformIOS {
+			result.ApnsID = resp.ID
+		} else {
+			result.MessageID = resp.ID
+		}
+		result.Reason = resp.Reason
+		// 410 时 APNs 返回 token 最后一次确认失效的时间
+		if !resp.Timestamp.IsZero() {
+			result.Timestamp = resp.Timestamp.UTC()
+		}
+	} else if err != nil {
+		result.Reason = errorReason(err)
+	}
+	return result
+}
+
+// errorReason 请求错误中的 URL 带有完整 token，只保留底层原因
+func errorReason(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return err.Error()
+}
+
+// MaskToken 只保留 token 首尾几位，避免在响应中泄露完整 token
+func MaskToken(token string) string {
+	if len(token) <= 12 {
+		return "****"
+	}
+	return token[:6] + "****" + token[len(token)-4:]
+}
@@ -0,0 +1,206 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+)
+
+// ScheduleBucket 定时推送保存的位置
+const ScheduleBucket = "scheduled"
+
+// scheduleMaxWait 没有到期消息时的最长等待时间
+const scheduleMaxWait = time.Minute
+
+// scheduleWake 新增定时推送后唤醒调度器重新计算等待时间
+var scheduleWake = make(chan struct{}, 1)
+
+// scheduleMu 保证取消定时推送和到期发送不会同时修改同一条记录
+var scheduleMu sync.Mutex
+
+// StartScheduler 启动定时推送调度器，ctx 取消时退出
+// 消息持久化在数据库中，重启后会立即补发已经到期的消息
+func StartScheduler(ctx context.Context) {
+	go func() {
+		for {
+			wait := scheduleMaxWait
+			if next := processScheduled(); !next.IsZero() {
+				wait = min(max(time.Until(next), 0), scheduleMaxWait)
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-scheduleWake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Schedule 保存定时推送，到期后通过 BatchPush 发送
+func Schedule(item *common.ScheduledData) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err = database.DB.SaveRecord(ScheduleBucket, item.ID, data); err != nil {
+		return err
+	}
+
+	select {
+	case scheduleWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ScheduledItems 返回所有等待发送的定时推送
+func ScheduledItems() ([]*common.ScheduledData, error) {
+	records, err := database.DB.Records(ScheduleBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*common.ScheduledData, 0, len(records))
+	for _, record := range records {
+		item := &common.ScheduledData{}
+		if err = json.Unmarshal(record.Data, item); err != nil {
+			log.Println(fmt.Sprintf("failed to decode scheduled push %s: %v", record.ID, err))
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ScheduledItem 返回指定 id 的定时推送
+func ScheduledItem(id string) (*common.ScheduledData, error) {
+	data, err := database.DB.RecordByID(ScheduleBucket, id)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &common.ScheduledData{}
+	if err = json.Unmarshal(data, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// CancelSchedule 取消尚未发送的定时推送
+func CancelSchedule(id string) error {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+
+	if _, err := database.DB.RecordByID(ScheduleBucket, id); err != nil {
+		return err
+	}
+	return database.DB.DeleteRecord(ScheduleBucket, id)
+}
+
+// CancelScheduleForKey 从定时推送中移除一个设备 key，只发往该 key 时取消整条推送
+// 其他接收者的推送不受影响，持有其中一个 key 不能取消发给其他设备的消息
+func CancelScheduleForKey(id, key string) error {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+
+	item, err := ScheduledItem(id)
+	if err != nil {
+		return err
+	}
+	if key == "" || item.Params == nil || !slices.Contains(item.Params.Keys, key) {
+		return database.ErrRecordNotFound
+	}
+
+	keys := slices.DeleteFunc(slices.Clone(item.Params.Keys), func(k string) bool { return k == key })
+	if len(keys) <= 0 && len(item.Params.Tokens) <= 0 {
+		return database.DB.DeleteRecord(ScheduleBucket, id)
+	}
+	item.Params.Keys = keys
+	return Schedule(item)
+}
+
+// processScheduled 发送所有到期的消息，返回下一条消息的发送时间
+func processScheduled() time.Time {
+	items, err := ScheduledItems()
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to load scheduled pushes: %v", err))
+		return time.Time{}
+	}
+
+	var next time.Time
+	now := common.DateNow()
+	for _, item := range items {
+		if item.SendAt.After(now) {
+			if next.IsZero() || item.SendAt.Before(next) {
+				next = item.SendAt
+			}
+			continue
+		}
+
+		// 先从数据库中移除，避免发送期间被重复处理
+		// 重新读取记录，期间被取消或移除了部分接收者时以最新的记录为准
+		current, err := takeScheduled(item.ID)
+		if err != nil {
+			if !errors.Is(err, database.ErrRecordNotFound) {
+				log.Println(fmt.Sprintf("failed to remove scheduled push %s: %v", item.ID, err))
+			}
+			continue
+		}
+		go deliverScheduled(current)
+	}
+	return next
+}
+
+// takeScheduled 读取并删除到期的定时推送
+func takeScheduled(id string) (*common.ScheduledData, error) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+
+	item, err := ScheduledItem(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = database.DB.DeleteRecord(ScheduleBucket, id); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func deliverScheduled(item *common.ScheduledData) {
+	if item.Params == nil {
+		return
+	}
+
+	// 发送时再查询 token，期间新注册或被清理的设备都能生效
+	ResolveTokens(item.Params)
+	if len(item.Params.Tokens) <= 0 {
+		log.Println(fmt.Sprintf("scheduled push %s dropped: no device token", item.ID))
+		return
+	}
+
+	_, err := BatchPush(item.Params, item.PushType)
+	if err != nil {
+		log.Println(fmt.Sprintf("scheduled push %s failed: %v", item.ID, err))
+	}
+
+	// 管理员的消息与即时推送一样进入重试队列，直到 App 确认收到
+	// 重试队列按消息 id 记录，App 回调确认时使用的也是消息 id
+	if id, _ := item.Params.Get(common.ID).(string); item.Admin && id != "" {
+		if err = Enqueue(id, item.Params, item.PushType, err); err != nil {
+			log.Println(fmt.Sprintf("failed to enqueue scheduled push %s: %v", item.ID, err))
+		}
+	}
+}
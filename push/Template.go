@@ -0,0 +1,70 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+)
+
+// TemplateBucket 推送模版保存的位置
+const TemplateBucket = "templates"
+
+// ErrTemplateNotFound 模版不存在
+var ErrTemplateNotFound = errors.New("template not found")
+
+// SaveTemplate 新增或更新推送模版
+func SaveTemplate(tmpl *common.Template) error {
+	data, err := json.Marshal(tmpl)
+	if err != nil {
+		return err
+	}
+	return database.DB.SaveRecord(TemplateBucket, tmpl.Name, data)
+}
+
+// Templates 返回所有推送模版
+func Templates() ([]*common.Template, error) {
+	records, err := database.DB.Records(TemplateBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make([]*common.Template, 0, len(records))
+	for _, record := range records {
+		tmpl := &common.Template{}
+		if err = json.Unmarshal(record.Data, tmpl); err != nil {
+			log.Println(fmt.Sprintf("failed to decode template %s: %v", record.ID, err))
+			continue
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// TemplateByName 返回指定名称的推送模版，用作 common.TemplateLookup
+func TemplateByName(name string) (*common.Template, error) {
+	data, err := database.DB.RecordByID(TemplateBucket, name)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &common.Template{}
+	if err = json.Unmarshal(data, tmpl); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// DeleteTemplate 删除推送模版
+func DeleteTemplate(name string) error {
+	if _, err := TemplateByName(name); err != nil {
+		return err
+	}
+	return database.DB.DeleteRecord(TemplateBucket, name)
+}
@@ -0,0 +1,286 @@
+package push
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/apns2"
+)
+
+const (
+	// vapidKeyFile 数据目录中保存 VAPID 私钥的文件
+	vapidKeyFile = "vapid_private.pem"
+	// vapidTokenLifetime VAPID JWT 的有效期，RFC 8292 要求不超过 24 小时
+	vapidTokenLifetime = 12 * time.Hour
+	// webPushTimeout 请求推送服务的超时时间
+	webPushTimeout = 30 * time.Second
+)
+
+// webPushTopic Topic 请求头只允许 32 个 base64url 字符
+var webPushTopic = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// webPushProvider 浏览器的推送通道，按 RFC 8030 / 8291 加密后发送到订阅中的推送服务
+type webPushProvider struct {
+	key    *ecdsa.PrivateKey
+	client *http.Client
+}
+
+// vapidKey 启用 Web Push 后的 VAPID 私钥
+var vapidKey *ecdsa.PrivateKey
+
+// CreateWebPushClient 读取数据目录中的 VAPID 私钥，不存在时生成
+func CreateWebPushClient() error {
+	key, err := loadVAPIDKey(common.BaseDir(vapidKeyFile))
+	if err != nil {
+		return err
+	}
+	vapidKey = key
+	RegisterProvider(&webPushProvider{key: key, client: webPushClient(common.LocalConfig.WebPush.AllowHTTP)})
+	return nil
+}
+
+// webPushClient 连接推送服务的客户端
+// 订阅地址由浏览器提交，解析结果可能在注册后改变，连接时再次检查只允许公网地址
+// allowHTTP 用于连接本地的 fake-apns，不限制地址
+func webPushClient(allowHTTP bool) *http.Client {
+	if allowHTTP {
+		return &http.Client{Timeout: webPushTimeout}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   webPushTimeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !common.PublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("web push endpoint %s is not a public address", address)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 不使用代理，代理会让地址检查只作用于代理本身
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: webPushTimeout, Transport: transport}
+}
+
+// VAPIDPublicKey 返回浏览器订阅时使用的 applicationServerKey，未启用时为空
+func VAPIDPublicKey() string {
+	if vapidKey == nil {
+		return ""
+	}
+	return vapidPublicKey(vapidKey)
+}
+
+func vapidPublicKey(key *ecdsa.PrivateKey) string {
+	public, _ := key.PublicKey.ECDH()
+	return base64.RawURLEncoding.EncodeToString(public.Bytes())
+}
+
+// loadVAPIDKey 私钥保存为 PKCS#8 PEM，首次启动时生成
+func loadVAPIDKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to save VAPID key: %v", err)
+		}
+		log.Println(fmt.Sprintf("generated VAPID key %s", path))
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid VAPID key %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID key %s: %v", path, err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("invalid VAPID key %s: not a P-256 key", path)
+	}
+	return key, nil
+}
+
+func (p *webPushProvider) Name() string {
+	return common.PlatformWeb
+}
+
+// Push 加密后发送到订阅的推送服务，状态码不是 2xx 时同时返回 *WebPushError
+func (p *webPushProvider) Push(params *common.ParamsMap, pushType apns2.EPushType, token common.TokenInfo) (*Response, error) {
+	if pushType == apns2.PushTypeLiveActivity {
+		return nil, errors.New("live activities are not supported on web")
+	}
+	sub := token.Subscription
+	if sub == nil {
+		return nil, errors.New("missing web push subscription")
+	}
+
+	delivery, err := common.ParseDelivery(params, pushType == apns2.PushTypeBackground)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery params: %v", err)
+	}
+
+	payload, err := json.Marshal(webPushMessage(params, pushType))
+	if err != nil {
+		return nil, err
+	}
+	body, err := common.EncryptWebPush(sub, payload)
+	if err != nil {
+		return nil, err
+	}
+	authorization, err := p.authorization(sub.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set(common.HeaderContentType, "application/octet-stream")
+	req.Header.Set("TTL", strconv.FormatInt(webPushTTL(delivery), 10))
+	if urgency := webPushUrgency(delivery); urgency != "" {
+		req.Header.Set("Urgency", urgency)
+	}
+	if id := common.PMGet(params, common.ID); webPushTopic.MatchString(id) {
+		req.Header.Set("Topic", id)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.New(errorReason(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	res := &Response{StatusCode: resp.StatusCode, ID: resp.Header.Get("Location")}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// 推送服务返回 201 Created，统一按 200 记录
+		res.StatusCode = http.StatusOK
+		return res, nil
+	}
+
+	reason, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	res.Reason = strings.TrimSpace(string(reason))
+	if res.Reason == "" {
+		res.Reason = http.StatusText(resp.StatusCode)
+	}
+	webErr := &WebPushError{Token: token.Token, StatusCode: resp.StatusCode, Reason: res.Reason}
+	if webErr.Class() == ReasonClassInvalidToken {
+		pruneToken(token.Token, res.Reason)
+	}
+	return res, webErr
+}
+
+// authorization 生成 RFC 8292 的 vapid 认证头，aud 为推送服务的 origin
+func (p *webPushProvider) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenLifetime).Unix(),
+		"sub": common.LocalConfig.WebPush.Subject,
+	}).SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s, k=%s", signed, vapidPublicKey(p.key)), nil
+}
+
+// webPushMessage 生成 service worker 收到的消息，静默推送没有 title 和 body
+func webPushMessage(params *common.ParamsMap, pushType apns2.EPushType) map[string]any {
+	message := map[string]any{}
+	if pushType != apns2.PushTypeBackground {
+		for _, key := range []string{common.Title, common.Subtitle, common.Body} {
+			if value := common.PMGet(params, key); value != "" {
+				message[key] = value
+			}
+		}
+	}
+	for _, key := range []string{common.ID, common.Group, common.Icon, common.URL} {
+		if value := common.PMGet(params, key); value != "" {
+			message[key] = value
+		}
+	}
+
+	data := map[string]any{}
+	for pair := params.Oldest(); pair != nil; pair = pair.Next() {
+		if _, skip := skipKeys[pair.Key]; skip {
+			continue
+		}
+		if _, ok := message[pair.Key]; ok {
+			continue
+		}
+		data[pair.Key] = pair.Value
+	}
+	if len(data) > 0 {
+		message["data"] = data
+	}
+	return message
+}
+
+// webPushTTL 推送服务保存离线消息的秒数，未设置过期时间时为 0，只投递给在线的浏览器
+func webPushTTL(delivery *common.Delivery) int64 {
+	now := common.DateNow()
+	expires := delivery.ExpiresAt(now)
+	if expires.IsZero() {
+		return 0
+	}
+	return int64(max(expires.Sub(now), 0).Seconds())
+}
+
+// webPushUrgency 按打断级别和优先级设置 Urgency，为空时由推送服务按 normal 处理
+func webPushUrgency(delivery *common.Delivery) string {
+	switch {
+	case delivery.Level == common.LevelCritical || delivery.Level == common.LevelTimeSensitive:
+		return "high"
+	case delivery.Level == common.LevelPassive || delivery.Priority == common.PriorityConserve:
+		return "very-low"
+	case delivery.Priority == common.PriorityLow:
+		return "low"
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package push
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunvc/NoLets/common"
+)
+
+func TestWebPushRejectsPrivateEndpoints(t *testing.T) {
+	for _, endpoint := range []string{
+		"https://127.0.0.1/push",
+		"https://localhost/push",
+		"https://10.0.0.1/push",
+		"https://192.168.1.1/push",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/push",
+		"https://[fe80::1]/push",
+		"https://0.0.0.0/push",
+	} {
+		sub := &common.WebPushSubscription{Endpoint: endpoint}
+		if err := sub.Check(false); err == nil || !strings.Contains(err.Error(), "public address") {
+			t.Errorf("expected %s to be rejected, got %v", endpoint, err)
+		}
+	}
+
+	// 注册后解析结果变成内网地址时，连接时同样拒绝
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	if _, err := webPushClient(false).Post(server.URL, "application/octet-stream", nil); err == nil {
+		t.Fatal("expected web push client to refuse a loopback endpoint")
+	}
+	resp, err := webPushClient(true).Post(server.URL, "application/octet-stream", nil)
+	if err != nil {
+		t.Fatalf("expected allow_http client to reach the local endpoint: %v", err)
+	}
+	_ = resp.Body.Close()
+}
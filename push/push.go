@@ -4,15 +4,103 @@ import (
 	"fmt"
 	"log"
 	"sync"
-	"time"
 
 	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
 	"github.com/sunvc/apns2"
 	"github.com/sunvc/apns2/payload"
 )
 
+// apnsProvider iOS 设备的推送通道
+type apnsProvider struct{}
+
+func (apnsProvider) Name() string {
+	return common.PlatformIOS
+}
+
 // Push message to APNs server
-func Push(params *common.ParamsMap, pushType apns2.EPushType, token string) error {
+// token 记录的 App 和环境决定使用哪个客户端池
+// 收到 APNs 响应时总是返回 resp，状态码不是 200 时同时返回 *APNsError
+func (apnsProvider) Push(params *common.ParamsMap, pushType apns2.EPushType, token common.TokenInfo) (*Response, error) {
+	var pl *payload.Payload
+	var liveActivity *common.LiveActivity
+
+	delivery, err := common.ParseDelivery(params, pushType == apns2.PushTypeBackground)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery params: %v", err)
+	}
+
+	if pushType == apns2.PushTypeLiveActivity {
+		la, err := common.ParseLiveActivity(params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid live activity params: %v", err)
+		}
+		if la == nil {
+			return nil, fmt.Errorf("invalid live activity params: missing %s", common.Event)
+		}
+		liveActivity = la
+		pl = liveActivityPayload(params, la)
+	} else {
+		pl = alertPayload(params, pushType, delivery)
+	}
+	if delivery.RelevanceScore != nil {
+		pl.RelevanceScore(float32(*delivery.RelevanceScore))
+	}
+
+	// 设备注册时选择的 App 决定使用的客户端池和 Topic
+	app, ok := common.AppleApp(token.App)
+	if !ok {
+		return nil, fmt.Errorf("unknown app %q", token.App)
+	}
+
+	topic := app.Topic
+	if liveActivity != nil {
+		topic += LiveActivityTopicSuffix
+	}
+
+	pool, ok := clientPool(app.ID(), selectPushMode(app, token.Env))
+	if !ok {
+		return nil, fmt.Errorf("no APNs client for app %q", app.ID())
+	}
+
+	// 创建并发送通知
+	resp, err := pool.Push(&apns2.Notification{
+		DeviceToken: token.Token,
+		CollapseID:  fmt.Sprint(params.Value(common.ID)),
+		Topic:       topic,
+		Payload:     pl,
+		Expiration:  delivery.ExpiresAt(common.DateNow()),
+		Priority:    delivery.Priority,
+		PushType:    pushType,
+	})
+
+	// 错误处理
+	if err != nil {
+		return nil, err
+	}
+	res := &Response{StatusCode: resp.StatusCode, ID: resp.ApnsID, Reason: resp.Reason, Timestamp: resp.Timestamp.Time}
+	if resp.StatusCode != 200 {
+		apnsErr := &APNsError{Token: token.Token, StatusCode: resp.StatusCode, Reason: resp.Reason}
+		if apnsErr.Class() == ReasonClassInvalidToken {
+			if liveActivity != nil {
+				removeLiveActivityToken(token)
+			} else {
+				pruneToken(token.Token, resp.Reason)
+			}
+		}
+		return res, apnsErr
+	}
+
+	// 活动结束后 token 不再可用
+	if liveActivity != nil && liveActivity.Event == common.LiveActivityEnd {
+		removeLiveActivityToken(token)
+	}
+	return res, nil
+
+}
+
+// alertPayload 生成普通提醒或静默推送的内容
+func alertPayload(params *common.ParamsMap, pushType apns2.EPushType, delivery *common.Delivery) *payload.Payload {
 	pl := payload.NewPayload().MutableContent()
 
 	if pushType == apns2.PushTypeBackground {
@@ -24,91 +112,177 @@ func Push(params *common.ParamsMap, pushType apns2.EPushType, token string) erro
 			Sound(common.PMGet(params, common.Sound)).
 			TargetContentID(common.PMGet(params, common.ID)).
 			ThreadID(common.PMGet(params, common.Group)).
-			Category(common.PMGet(params, common.Category))
-	}
+			Category(common.PMGet(params, common.Category)).
+			InterruptionLevel(interruptionLevels[delivery.Level])
 
-	// 添加自定义参数
-	skipKeys := map[string]struct{}{
-		common.DeviceKey:   {},
-		common.DeviceKeys:  {},
-		common.DeviceToken: {},
-		common.Title:       {},
-		common.Body:        {},
-		common.Sound:       {},
-		common.Category:    {},
+		// 重要提醒需要使用 sound 字典并设置 critical 和音量
+		if delivery.Level == common.LevelCritical {
+			sound := common.PMGet(params, common.Sound)
+			if sound == "" {
+				sound = "default"
+			}
+			pl.SoundName(sound).SoundVolume(float32(delivery.Volume))
+		}
 	}
 
+	// 添加自定义参数
 	for pair := params.Oldest(); pair != nil; pair = pair.Next() {
 		if _, skip := skipKeys[pair.Key]; skip {
 			continue
 		}
 		pl.Custom(pair.Key, pair.Value)
 	}
+	return pl
+}
 
-	CLI := <-CLIENTS // 从池中获取一个客户端
-	CLIENTS <- CLI   // 将客户端放回池中
+// skipKeys 不作为自定义参数发送给设备的参数
+var skipKeys = map[string]struct{}{
+	common.DeviceKey:      {},
+	common.DeviceKeys:     {},
+	common.DeviceToken:    {},
+	common.Title:          {},
+	common.Body:           {},
+	common.Sound:          {},
+	common.Category:       {},
+	common.TTL:            {},
+	common.Expiration:     {},
+	common.Priority:       {},
+	common.RelevanceScore: {},
+	common.Encrypt:        {},
+	common.LongMessage:    {},
+	common.IdempotencyKey: {},
+}
 
-	// 创建并发送通知
-	resp, err := CLI.Push(&apns2.Notification{
-		DeviceToken: token,
-		CollapseID:  fmt.Sprint(params.Value(common.ID)),
-		Topic:       common.LocalConfig.Apple.Topic,
-		Payload:     pl,
-		Expiration:  common.DateNow().Add(24 * time.Hour),
-		PushType:    pushType,
-	})
+// interruptionLevels level 参数对应的 aps.interruption-level
+var interruptionLevels = map[string]payload.EInterruptionLevel{
+	common.LevelPassive:       payload.InterruptionLevelPassive,
+	common.LevelActive:        payload.InterruptionLevelActive,
+	common.LevelTimeSensitive: payload.InterruptionLevelTimeSensitive,
+	common.LevelCritical:      payload.InterruptionLevelCritical,
+}
 
-	// 错误处理
-	if err != nil {
-		return err
+// ResolveTokens 未直接指定 token 时，从数据库中查询每个 key 下注册的所有 token
+func ResolveTokens(params *common.ParamsResult) {
+	if len(params.Tokens) <= 0 && params.LiveActivity != nil {
+		resolveLiveActivityTokens(params)
+	} else if len(params.Tokens) <= 0 {
+		for _, key := range params.Keys {
+			if len(key) > 5 {
+				// 一个 key 下可能注册了多台设备，逐个推送
+				if tokens, err := database.DB.DeviceTokensByKey(key); err == nil {
+					for _, token := range tokens {
+						token.Key = key
+						params.Tokens = append(params.Tokens, token)
+					}
+				}
+			}
+		}
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("APNs push failed: %s", resp.Reason)
+
+	params.Tokens = uniqueTokens(params.Tokens)
+}
+
+// uniqueTokens 按 token 去重，多个 key 可能注册了同一台设备
+func uniqueTokens(tokens []common.TokenInfo) []common.TokenInfo {
+	seen := make(map[string]struct{}, len(tokens))
+	result := make([]common.TokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		if _, ok := seen[token.Token]; !ok {
+			seen[token.Token] = struct{}{}
+			result = append(result, token)
+		}
 	}
-	return nil
+	return result
+}
 
+// SelectPushType 如果 title, subtitle 和 body 都为空，设置静默推送模式
+// 带有 event 参数时为实时活动推送
+func SelectPushType(params *common.ParamsResult) apns2.EPushType {
+	if params.LiveActivity != nil {
+		return apns2.PushTypeLiveActivity
+	}
+	if params.PushType == 0 {
+		return apns2.PushTypeBackground
+	}
+	return apns2.PushTypeAlert
 }
 
-func BatchPush(params *common.ParamsResult, pushType apns2.EPushType) error {
+// BatchPush 通过派发队列把每个 token × 每段内容交给 worker 发送，并等待全部完成
+// 返回每个 token 的推送结果，有推送失败时同时返回错误
+// 队列已满时剩余的推送在结果中标记为未发送并返回 ErrDispatchBusy，已经派发的推送仍会等待完成
+func BatchPush(params *common.ParamsResult, pushType apns2.EPushType) (*PushReport, error) {
+	// 长消息保存在服务端时只推送一条预览，保存失败时仍然拆分发送
+	if len(params.Results) > 0 && common.LongMessageMode(params.Params) == common.LongMessageStore {
+		if err := storeLongMessage(params); err != nil {
+			log.Println(fmt.Sprintf("failed to store long message, sending in parts: %v", err))
+		}
+	}
+
+	payloads := params.Results
+	if len(payloads) <= 0 {
+		payloads = []*common.ParamsMap{params.Params}
+	}
+
+	report := &PushReport{Results: []*DeliveryResult{}}
+	total := len(params.Tokens) * len(payloads)
+	if limit := common.LocalConfig.System.MaxBatchPushCount; limit > 0 && total > limit {
+		return report, fmt.Errorf("%w: %d exceeds max_batch_push_count %d", ErrBatchTooLarge, total, limit)
+	}
 
 	var (
-		errors []error
-		mu     sync.Mutex
-		wg     sync.WaitGroup
+		errs []error
+		mu   sync.Mutex
+		wg   sync.WaitGroup
 	)
-	
+
+	var dispatchErr error
 	for _, token := range params.Tokens {
-		if len(params.Results) > 0 {
-			for _, param := range params.Results {
-				wg.Add(1)
-				go func(p *common.ParamsMap) {
-					defer wg.Done()
-					if err := Push(p, pushType, token); err != nil {
-						log.Println(err.Error())
-						mu.Lock()
-						errors = append(errors, err)
-						mu.Unlock()
-					}
-				}(param)
+		for i, p := range payloads {
+			part := 0
+			if len(payloads) > 1 {
+				part = i + 1
+			}
+
+			// 队列已满后不再等待，剩余的推送直接记录为未发送，已经发送的 token 不受影响
+			if dispatchErr != nil {
+				mu.Lock()
+				report.add(newDeliveryResult(token, part, nil, dispatchErr))
+				mu.Unlock()
+				continue
 			}
-		} else {
+
 			wg.Add(1)
-			go func(p *common.ParamsMap) {
+			job := &pushJob{params: p, pushType: pushType, token: token}
+			job.done = func(resp *Response, err error) {
 				defer wg.Done()
-				if err := Push(params.Params, pushType, token); err != nil {
+				if err != nil {
 					log.Println(err.Error())
-					mu.Lock()
-					errors = append(errors, err)
-					mu.Unlock()
 				}
-			}(params.Params)
+
+				mu.Lock()
+				defer mu.Unlock()
+				report.add(newDeliveryResult(token, part, resp, err))
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+
+			if dispatchErr = dispatch(job); dispatchErr != nil {
+				wg.Done()
+				mu.Lock()
+				report.add(newDeliveryResult(token, part, nil, dispatchErr))
+				mu.Unlock()
+			}
 		}
 	}
 
 	wg.Wait()
-	if len(errors) > 0 {
-		return fmt.Errorf("APNs push failed: %v", errors)
+	if dispatchErr != nil {
+		return report, dispatchErr
+	}
+	if len(errs) > 0 {
+		return report, fmt.Errorf("push failed: %v", errs)
 	}
 
-	return nil
+	return report, nil
 }
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+)
+
+func TestMain(m *testing.M) {
+	dataDir, err := os.MkdirTemp("", "nolet-router-test")
+	if err != nil {
+		panic(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	common.LocalConfig.System.Name = "NoLetTest"
+	common.LocalConfig.System.Auths = []string{"admintoken"}
+	database.DB = database.NewBboltdb(dataDir)
+
+	code := m.Run()
+	_ = database.DB.Close()
+	_ = os.RemoveAll(dataDir)
+	os.Exit(code)
+}
+
+// browserSubscription 模拟浏览器 pushManager.subscribe() 返回的订阅
+func browserSubscription(t *testing.T) map[string]any {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	_, _ = rand.Read(auth)
+	return map[string]any{
+		"endpoint": "https://8.8.8.8/wpush/v2/browser-subscription",
+		"keys": map[string]string{
+			"p256dh": base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			"auth":   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	}
+}
+
+// postSubscription 按浏览器 fetch 的方式提交订阅，返回响应体
+func postSubscription(t *testing.T, engine *gin.Engine, authorization string, body map[string]any) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/webpush", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15")
+	req.Header.Set("Origin", "https://push.example.com")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected http status %d", w.Code)
+	}
+	var res map[string]any
+	if err = json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestBrowserRegistersWebPushSubscription(t *testing.T) {
+	engine := gin.New()
+	engine.Use(Verification())
+	SetupRouter(engine)
+
+	body := map[string]any{"name": "browser", "subscription": browserSubscription(t)}
+
+	// 浏览器没有 App 的 User-Agent 和签名，未登录时拒绝
+	if res := postSubscription(t, engine, "", body); res["code"] != float64(http.StatusUnauthorized) {
+		t.Fatalf("expected unauthenticated browser to be rejected, got %v", res)
+	}
+
+	res := postSubscription(t, engine, "admintoken", body)
+	if res["code"] != float64(http.StatusOK) {
+		t.Fatalf("expected browser subscription to be registered, got %v", res)
+	}
+	data, _ := res["data"].(map[string]any)
+	key, _ := data["key"].(string)
+	token, _ := data["token"].(string)
+
+	tokens, err := database.DB.DeviceTokensByKey(key)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("expected 1 registered subscription, got %+v (%v)", tokens, err)
+	}
+	if tokens[0].Token != token || tokens[0].Platform != common.PlatformWeb || tokens[0].Subscription == nil {
+		t.Fatalf("unexpected registered token: %+v", tokens[0])
+	}
+}
@@ -19,7 +19,8 @@ func Verification() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 
-		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodPost {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodPost &&
+			c.Request.Method != http.MethodPut && c.Request.Method != http.MethodDelete {
 			c.AbortWithStatus(http.StatusMethodNotAllowed)
 			return
 		}
@@ -65,6 +66,20 @@ func Verification() gin.HandlerFunc {
 	}
 }
 
+// AdminOnly 仅允许管理员访问
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !common.Admin(c) {
+			c.AbortWithStatusJSON(http.StatusOK, common.Failed(
+				http.StatusUnauthorized,
+				"admin authorization required",
+			))
+			return
+		}
+		c.Next()
+	}
+}
+
 // CheckDotParamMiddleware 检查 GET 请求第一个 path 参数是否包含 '.'
 func CheckDotParamMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -78,10 +93,32 @@ func CheckDotParamMiddleware() gin.HandlerFunc {
 	}
 }
 
+// AdminOrGCMDecryptMiddleware 管理员直接放行，否则要求 App 签名校验
+func AdminOrGCMDecryptMiddleware() gin.HandlerFunc {
+	return AdminOrGCMDecryptLimitMiddleware(512)
+}
+
+// AdminOrGCMDecryptLimitMiddleware 与 AdminOrGCMDecryptMiddleware 相同，App 请求的请求体最大为 limit
+func AdminOrGCMDecryptLimitMiddleware(limit int64) gin.HandlerFunc {
+	verify := GCMDecryptLimitMiddleware(limit)
+	return func(c *gin.Context) {
+		if common.Admin(c) {
+			c.Next()
+			return
+		}
+		verify(c)
+	}
+}
+
 func GCMDecryptMiddleware() gin.HandlerFunc {
+	return GCMDecryptLimitMiddleware(512)
+}
+
+// GCMDecryptLimitMiddleware 与 GCMDecryptMiddleware 相同，请求体最大为 limit，用于浏览器订阅等较大的请求
+func GCMDecryptLimitMiddleware(limit int64) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 512)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
 
 		userAgent := c.GetHeader(common.HeaderUserAgent)
 		if !strings.HasPrefix(userAgent, common.LocalConfig.System.Name) {
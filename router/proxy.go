@@ -0,0 +1,18 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+)
+
+// SetupTrustedProxies 配置 gin 获取客户端 IP 的方式
+// 未配置 proxy_header 时不信任任何代理，直接使用连接的远程地址
+func SetupTrustedProxies(engine *gin.Engine) error {
+	system := common.LocalConfig.System
+	if system.ProxyHeader == "" {
+		return engine.SetTrustedProxies(nil)
+	}
+
+	engine.RemoteIPHeaders = []string{system.ProxyHeader}
+	return engine.SetTrustedProxies(system.TrustedProxies)
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sunvc/NoLets/common"
+	"github.com/sunvc/NoLets/database"
+)
+
+// rateLimitStore 由 SetupRouter 创建，所有限流中间件共用
+var rateLimitStore database.RateLimitStore
+
+type rateLimitCheck struct {
+	id    string
+	limit int // 每分钟允许的请求数
+}
+
+// RateLimit 令牌桶限流，超出限制时返回 429
+// 管理员只按 token 计数；其他请求按客户端 IP 计数，withKeys 为 true 时再按请求中的每个设备 key 计数
+func RateLimit(withKeys bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		system := common.LocalConfig.System
+
+		var checks []rateLimitCheck
+		if common.Admin(c) {
+			checks = append(checks, rateLimitCheck{id: "admin:" + adminIdentity(c), limit: system.RateLimitAdmin})
+		} else {
+			checks = append(checks, rateLimitCheck{id: "ip:" + common.ClientIP(c), limit: system.RateLimitIP})
+			if withKeys && system.RateLimitKey > 0 {
+				for _, key := range common.RequestDeviceKeys(c) {
+					checks = append(checks, rateLimitCheck{id: "key:" + key, limit: system.RateLimitKey})
+				}
+			}
+		}
+
+		var current *database.RateLimitResult
+		for _, check := range checks {
+			if check.limit <= 0 {
+				continue
+			}
+
+			result, err := rateLimitStore.Take(check.id, float64(check.limit)/60, check.limit)
+			if err != nil {
+				// 计数存储不可用时放行，不影响正常推送
+				log.Println("failed to take rate limit token:", err)
+				continue
+			}
+
+			if !result.Allowed {
+				setRateLimitHeaders(c, result)
+				c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, common.Failed(
+					http.StatusTooManyRequests,
+					"too many requests, retry after %ds", ceilSeconds(result.RetryAfter),
+				))
+				return
+			}
+
+			// 返回剩余次数最少的限制
+			if current == nil || result.Remaining < current.Remaining {
+				current = &result
+			}
+		}
+
+		if current != nil {
+			setRateLimitHeaders(c, *current)
+		}
+		c.Next()
+	}
+}
+
+func setRateLimitHeaders(c *gin.Context, result database.RateLimitResult) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+// adminIdentity 区分不同管理员凭证，计数时只保存摘要
+func adminIdentity(c *gin.Context) string {
+	identity := c.GetHeader("Authorization")
+	if identity == "" {
+		identity = common.LocalConfig.System.User
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:8])
+}
+
+func ceilSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 1)
+}
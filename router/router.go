@@ -3,9 +3,13 @@ package router
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/sunvc/NoLets/controller"
+	"github.com/sunvc/NoLets/database"
 )
 
 func SetupRouter(router *gin.Engine) {
+	rateLimitStore = database.NewRateLimitStore()
+	ipLimit := RateLimit(false)
+	keyLimit := RateLimit(true)
 
 	router.GET("/", controller.Home)
 	router.GET("/info", controller.Info)
@@ -16,27 +20,83 @@ func SetupRouter(router *gin.Engine) {
 	router.GET("/monitor", controller.GetServerInfo)
 
 	// 注册
-	router.GET("/register/:deviceKey", GCMDecryptMiddleware(), controller.Register)
-	router.POST("/register", GCMDecryptMiddleware(), controller.Register)
+	router.GET("/register/:deviceKey", ipLimit, GCMDecryptMiddleware(), controller.Register)
+	router.POST("/register", ipLimit, GCMDecryptMiddleware(), controller.Register)
+	router.DELETE("/register/:deviceKey", ipLimit, AdminOrGCMDecryptMiddleware(), controller.Unregister)
+
+	// 实时活动 token
+	router.POST("/liveactivity", ipLimit, GCMDecryptMiddleware(), controller.RegisterLiveActivity)
+	router.GET("/liveactivity/:deviceKey", AdminOnly(), controller.GetLiveActivityTokens)
+	router.DELETE("/liveactivity/:deviceKey", ipLimit, AdminOrGCMDecryptMiddleware(), controller.UnregisterLiveActivity)
+
+	// 浏览器订阅 Web Push
+	router.GET("/webpush/key", controller.WebPushKey)
+	router.POST("/webpush", ipLimit, AdminOrGCMDecryptLimitMiddleware(controller.MaxSubscriptionSize), controller.RegisterWebPush)
+	router.DELETE("/webpush/:deviceKey", ipLimit, AdminOrGCMDecryptMiddleware(), controller.UnregisterWebPush)
+	router.GET("/sw.js", controller.ServiceWorker)
 
 	router.GET("/upload", controller.Upload)
 	router.POST("/upload", controller.Upload)
+
+	// 上传的图片
+	router.GET("/images/:name", controller.ServeImage)
+	images := router.Group("/images", AdminOnly())
+	{
+		images.GET("", controller.GetImages)
+		images.PUT("/:name", controller.RenameImage)
+		images.DELETE("/:name", controller.DeleteImage)
+	}
 	router.GET("/.well-known/apple-app-site-association", controller.AppleSite)
+
+	// 重试队列管理
+	queue := router.Group("/queue", AdminOnly())
+	{
+		queue.GET("", controller.GetQueue)
+		queue.DELETE("/:id", controller.DeleteQueueItem)
+		queue.GET("/dead", controller.GetDeadQueue)
+		queue.DELETE("/dead/:id", controller.DeleteDeadItem)
+		queue.POST("/dead/:id/retry", controller.RetryDeadItem)
+	}
+
+	// 定时推送
+	router.GET("/schedule", keyLimit, controller.GetScheduled)
+	router.DELETE("/schedule/:id", keyLimit, controller.CancelScheduled)
+
+	// App 获取服务端保存的长消息
+	router.GET("/message/:id", ipLimit, controller.GetMessage)
+
+	// 推送模版
+	templates := router.Group("/template", AdminOnly())
+	{
+		templates.GET("", controller.GetTemplates)
+		templates.POST("", controller.CreateTemplate)
+		templates.GET("/:name", controller.GetTemplate)
+		templates.PUT("/:name", controller.UpdateTemplate)
+		templates.DELETE("/:name", controller.DeleteTemplate)
+	}
+
+	// 周期推送
+	router.GET("/cron", keyLimit, controller.GetCronJobs)
+	router.POST("/cron", keyLimit, controller.CreateCronJob)
+	router.GET("/cron/:id", keyLimit, controller.GetCronJob)
+	router.PUT("/cron/:id", keyLimit, controller.UpdateCronJob)
+	router.DELETE("/cron/:id", keyLimit, controller.DeleteCronJob)
+
 	// 推送请求
-	router.POST("/push", controller.BasePush)
+	router.POST("/push", keyLimit, controller.BasePush)
 	// 获取设备Token
-	router.GET("/:deviceKey/token", controller.GetDeviceToken)
+	router.GET("/:deviceKey/token", ipLimit, controller.GetDeviceToken)
 	// title subtitle body
-	router.GET("/:deviceKey/:params1/:params2/:params3", controller.BasePush)
-	router.POST("/:deviceKey/:params1/:params2/:params3", controller.BasePush)
+	router.GET("/:deviceKey/:params1/:params2/:params3", keyLimit, controller.BasePush)
+	router.POST("/:deviceKey/:params1/:params2/:params3", keyLimit, controller.BasePush)
 	// title body
-	router.GET("/:deviceKey/:params1/:params2", controller.BasePush)
-	router.POST("/:deviceKey/:params1/:params2", controller.BasePush)
+	router.GET("/:deviceKey/:params1/:params2", keyLimit, controller.BasePush)
+	router.POST("/:deviceKey/:params1/:params2", keyLimit, controller.BasePush)
 	// body
-	router.GET("/:deviceKey/:params1", controller.BasePush)
-	router.POST("/:deviceKey/:params1", controller.BasePush)
+	router.GET("/:deviceKey/:params1", keyLimit, controller.BasePush)
+	router.POST("/:deviceKey/:params1", keyLimit, controller.BasePush)
 
 	// 参数化的推送
-	router.GET("/:deviceKey", CheckDotParamMiddleware(), controller.BasePush)
-	router.POST("/:deviceKey", controller.BasePush)
+	router.GET("/:deviceKey", CheckDotParamMiddleware(), keyLimit, controller.BasePush)
+	router.POST("/:deviceKey", keyLimit, controller.BasePush)
 }
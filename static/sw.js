@@ -0,0 +1,52 @@
+// NoLets Web Push service worker
+// 页面注册示例：
+//   const reg = await navigator.serviceWorker.register('/sw.js');
+//   const {data} = await (await fetch('/webpush/key')).json();
+//   const sub = await reg.pushManager.subscribe({userVisibleOnly: true, applicationServerKey: data.publicKey});
+//   // 浏览器使用管理员身份提交订阅，App 使用与 /register 相同的签名
+//   await fetch('/webpush', {method: 'POST', headers: {'Content-Type': 'application/json', 'Authorization': '<auth>'},
+//     body: JSON.stringify({key: '<device key>', subscription: sub})});
+
+self.addEventListener('install', () => self.skipWaiting());
+self.addEventListener('activate', (event) => event.waitUntil(self.clients.claim()));
+
+self.addEventListener('push', (event) => {
+    let message = {};
+    try {
+        message = event.data ? event.data.json() : {};
+    } catch (e) {
+        message = {body: event.data ? event.data.text() : ''};
+    }
+
+    // 静默推送没有标题和内容，不显示通知
+    if (!message.title && !message.body) {
+        return;
+    }
+
+    const title = message.title || message.subtitle || 'NoLets';
+    const body = message.title && message.subtitle
+        ? message.subtitle + '\n' + (message.body || '')
+        : (message.body || '');
+
+    event.waitUntil(self.registration.showNotification(title, {
+        body: body,
+        icon: message.icon || '/logo.png',
+        tag: message.id || message.group || undefined,
+        renotify: Boolean(message.id),
+        data: {url: message.url || '/', id: message.id, data: message.data || {}},
+    }));
+});
+
+self.addEventListener('notificationclick', (event) => {
+    event.notification.close();
+    const url = new URL(event.notification.data.url || '/', self.location.origin).href;
+
+    event.waitUntil(self.clients.matchAll({type: 'window', includeUncontrolled: true}).then((clients) => {
+        for (const client of clients) {
+            if (client.url === url && 'focus' in client) {
+                return client.focus();
+            }
+        }
+        return self.clients.openWindow(url);
+    }));
+});